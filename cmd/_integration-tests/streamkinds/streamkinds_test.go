@@ -0,0 +1,80 @@
+// Package test exercises svcdef's end-to-end handling of all four gRPC
+// stream kinds (unary, server-stream, client-stream, bidi-stream) through
+// the full New pipeline, the way middlewares_test.go exercises middleware
+// wrapping through a running service.
+//
+// middlewares_test.go calls through generated go-kit endpoints built by the
+// full protoc/truss codegen pipeline against a checked-in .proto/.go pair;
+// this repo snapshot has no such generated stream-kind fixture service to
+// call through, so this test instead drives svcdef.New directly against a
+// protoc-gen-go-shaped source fixture covering all four stream kinds at
+// once, and asserts each method lands in the StreamKind the real generated
+// client/server code for it would imply.
+package test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/TuneLab/go-truss/svcdef"
+)
+
+func TestAllStreamKinds(t *testing.T) {
+	goSrc := `
+package pb
+
+type StreamKindsRequest struct {
+	Name string ` + "`protobuf:\"bytes,1,opt,name=name\" json:\"name,omitempty\"`" + `
+}
+
+type StreamKindsResponse struct {
+	Name string ` + "`protobuf:\"bytes,1,opt,name=name\" json:\"name,omitempty\"`" + `
+}
+
+type StreamKindsServer interface {
+	GetThing(context.Context, *StreamKindsRequest) (*StreamKindsResponse, error)
+	ListThings(*StreamKindsRequest, StreamKinds_ListThingsServer) error
+	SendThings(StreamKinds_SendThingsServer) error
+	ThingFeed(StreamKinds_ThingFeedServer) error
+}
+
+type StreamKinds_ListThingsServer interface {
+	Send(*StreamKindsResponse) error
+	grpc.ServerStream
+}
+
+type StreamKinds_SendThingsServer interface {
+	SendAndClose(*StreamKindsResponse) error
+	Recv() (*StreamKindsRequest, error)
+	grpc.ServerStream
+}
+
+type StreamKinds_ThingFeedServer interface {
+	Send(*StreamKindsResponse) error
+	Recv() (*StreamKindsRequest, error)
+	grpc.ServerStream
+}
+`
+	sd, err := svcdef.New(map[string]io.Reader{"streamkinds.pb.go": strings.NewReader(goSrc)}, nil)
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+
+	want := map[string]svcdef.StreamKind{
+		"GetThing":   svcdef.UnaryStream,
+		"ListThings": svcdef.ServerStream,
+		"SendThings": svcdef.ClientStream,
+		"ThingFeed":  svcdef.BidiStream,
+	}
+	got := map[string]svcdef.StreamKind{}
+	for _, m := range sd.Service.Methods {
+		got[m.Name] = m.StreamKind
+	}
+
+	for name, kind := range want {
+		if got[name] != kind {
+			t.Errorf("expected %s to be %s, got %s", name, kind, got[name])
+		}
+	}
+}
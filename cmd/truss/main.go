@@ -0,0 +1,170 @@
+// Command truss builds a service definition from a set of protoc-gen-go
+// generated Go files and their originating .proto files, and renders from it
+// the user templates named by --template-dir and the GraphQL schema and
+// resolvers named by --graphql. It generates no other code.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+
+	"github.com/TuneLab/go-truss/gengraphql"
+	"github.com/TuneLab/go-truss/svcdef"
+	"github.com/TuneLab/go-truss/svcdef/template"
+)
+
+var (
+	templateDir = flag.String("template-dir", "",
+		"directory of user-defined *.tmpl files to render against the service definition")
+
+	graphqlOut = flag.String("graphql", "",
+		"directory to write a generated schema.graphql and resolvers.go into; empty disables GraphQL generation")
+	svcImportPath = flag.String("svc-import-path", "",
+		"import path of the generated service package; required when --graphql is set")
+)
+
+func main() {
+	flag.Parse()
+
+	if err := run(flag.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, "truss:", err)
+		os.Exit(1)
+	}
+}
+
+func run(paths []string) error {
+	goFiles, protoFiles, err := readDefinitionFiles(paths)
+	if err != nil {
+		return err
+	}
+
+	sd, err := svcdef.New(goFiles, protoFiles)
+	if err != nil {
+		return errors.Wrap(err, "cannot build service definition")
+	}
+
+	if *templateDir != "" {
+		files, err := template.Plugin(*templateDir, sd)
+		if err != nil {
+			return errors.Wrapf(err, "cannot render templates from %q", *templateDir)
+		}
+		if err := writeFiles(files); err != nil {
+			return err
+		}
+	}
+
+	if *graphqlOut != "" {
+		if err := writeGraphQL(sd, *graphqlOut, *svcImportPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeGraphQL renders sd's GraphQL schema and resolver stubs into dir, as
+// schema.graphql and resolvers.go. If resolvers.go already exists in dir,
+// its content is passed to gengraphql.Resolvers as prev so that user edits
+// to resolver bodies survive regeneration.
+func writeGraphQL(sd *svcdef.Svcdef, dir, svcImportPath string) error {
+	if svcImportPath == "" {
+		return errors.New("--svc-import-path is required when --graphql is set")
+	}
+
+	schema, err := gengraphql.Schema(sd)
+	if err != nil {
+		return errors.Wrap(err, "cannot render graphql schema")
+	}
+
+	resolversPath := filepath.Join(dir, "resolvers.go")
+	var prev string
+	if data, err := ioutil.ReadFile(resolversPath); err == nil {
+		prev = string(data)
+	}
+	resolvers, err := gengraphql.Resolvers(sd, packageName(dir), svcImportPath, prev)
+	if err != nil {
+		return errors.Wrap(err, "cannot render graphql resolvers")
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrapf(err, "cannot create directory %q", dir)
+	}
+	schemaPath := filepath.Join(dir, "schema.graphql")
+	if err := ioutil.WriteFile(schemaPath, []byte(schema), 0644); err != nil {
+		return errors.Wrapf(err, "cannot write %q", schemaPath)
+	}
+	if err := ioutil.WriteFile(resolversPath, []byte(resolvers), 0644); err != nil {
+		return errors.Wrapf(err, "cannot write %q", resolversPath)
+	}
+	return nil
+}
+
+// packageName derives a Go package name from dir's base name, replacing any
+// rune that can't appear in a Go identifier with "_" - an output directory
+// like "graphql-gateway" would otherwise produce an unparseable "package
+// graphql-gateway" declaration.
+func packageName(dir string) string {
+	base := filepath.Base(dir)
+	var b strings.Builder
+	for i, r := range base {
+		switch {
+		case unicode.IsLetter(r) || r == '_':
+			b.WriteRune(r)
+		case unicode.IsDigit(r) && i > 0:
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
+
+// readDefinitionFiles reads each path in paths, sorting it into goFiles or
+// protoFiles by its extension, ready to pass to svcdef.New.
+func readDefinitionFiles(paths []string) (goFiles, protoFiles map[string]io.Reader, err error) {
+	goFiles = map[string]io.Reader{}
+	protoFiles = map[string]io.Reader{}
+
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "cannot read %q", path)
+		}
+
+		switch filepath.Ext(path) {
+		case ".go":
+			goFiles[path] = bytes.NewReader(data)
+		case ".proto":
+			protoFiles[path] = bytes.NewReader(data)
+		default:
+			return nil, nil, errors.Errorf("%q is neither a .go nor a .proto file", path)
+		}
+	}
+
+	return goFiles, protoFiles, nil
+}
+
+// writeFiles writes each rendered template.File to disk at its Path,
+// creating any directories along the way.
+func writeFiles(files []template.File) error {
+	for _, f := range files {
+		if dir := filepath.Dir(f.Path); dir != "." {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return errors.Wrapf(err, "cannot create directory for %q", f.Path)
+			}
+		}
+		if err := ioutil.WriteFile(f.Path, f.Data, 0644); err != nil {
+			return errors.Wrapf(err, "cannot write %q", f.Path)
+		}
+	}
+	return nil
+}
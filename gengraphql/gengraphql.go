@@ -0,0 +1,410 @@
+// Package gengraphql generates a GraphQL gateway from a *svcdef.Svcdef, in
+// the spirit of gqlgen's schema-first workflow: a `.graphql` schema, where
+// each Message becomes a `type` (plus a companion `input` type for any
+// message used as a method's request, since GraphQL forbids an object type
+// as an argument type), each Enum a custom `scalar` (svcdef.Enum carries no
+// values, so no `enum` type with members is emitted), and each RPC method a `Query`
+// field by default, a `Mutation` field if its HTTP binding's verb is
+// POST/PUT/PATCH/DELETE, or a `Subscription` field if it's a server-stream;
+// and Go resolver stubs that delegate to the service's existing go-kit
+// endpoints.
+//
+// Regenerating resolvers preserves user edits: each resolver body is
+// bracketed by a `// truss:resolver:begin NAME` / `// truss:resolver:end`
+// marker pair, and Resolvers keeps whatever was last written inside a
+// matching pair of markers, discarding only the generated wrapper around it.
+package gengraphql
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+
+	"github.com/TuneLab/go-truss/svcdef"
+)
+
+// scalarTypes maps a protobuf/Go scalar type name, as it appears in
+// svcdef.FieldType.Name, to the GraphQL scalar that represents it.
+//
+//     | Proto/Go type                             | GraphQL scalar |
+//     |--------------------------------------------|----------------|
+//     | string                                     | String         |
+//     | bool                                       | Boolean        |
+//     | float32, float64                           | Float          |
+//     | int32, uint32, sint32, fixed32, sfixed32   | Int            |
+//     | int64, uint64, sint64, fixed64, sfixed64   | String         |
+//     | byte (bytes)                               | String         |
+//
+// GraphQL's Int is a signed 32-bit integer, too narrow for a 64-bit proto
+// field, so 64-bit integer types are represented as String instead, mirroring
+// how protobuf's own JSON mapping handles them.
+var scalarTypes = map[string]string{
+	"string":   "String",
+	"bool":     "Boolean",
+	"float32":  "Float",
+	"float64":  "Float",
+	"int32":    "Int",
+	"uint32":   "Int",
+	"sint32":   "Int",
+	"fixed32":  "Int",
+	"sfixed32": "Int",
+	"int64":    "String",
+	"uint64":   "String",
+	"sint64":   "String",
+	"fixed64":  "String",
+	"sfixed64": "String",
+	"byte":     "String",
+}
+
+// mutationVerbs are the HTTP verbs whose presence on a ServiceMethod's first
+// binding causes it to be emitted as a Mutation field rather than a Query.
+var mutationVerbs = map[string]bool{
+	"POST":   true,
+	"PUT":    true,
+	"PATCH":  true,
+	"DELETE": true,
+}
+
+// schemaBuilder accumulates the body of a GraphQL schema document as it's
+// rendered, along with two kinds of auxiliary type definitions discovered
+// along the way: entries holds one rendered `type XMapEntry { ... }` block
+// per distinct `map<K, V>` shape graphQLType encounters (GraphQL has no
+// native map type, so a map field is represented as a list of key/value
+// pairs, the same approach gqlgen takes), and inputs holds one rendered
+// `input XInput { ... }` block per message used as a method's request type
+// (GraphQL forbids an object `type` as a field argument's type, so each
+// request message needs its own `input` counterpart). Both are keyed by
+// their type name so the same shape is only defined once.
+type schemaBuilder struct {
+	buf     bytes.Buffer
+	entries map[string]string
+	inputs  map[string]string
+}
+
+// Schema renders sd as a GraphQL schema document.
+func Schema(sd *svcdef.Svcdef) (string, error) {
+	b := &schemaBuilder{entries: map[string]string{}, inputs: map[string]string{}}
+
+	for _, enum := range sd.Enums {
+		// svcdef.Enum does not yet carry its values, only its name; until it
+		// does, represent it as an opaque String-backed scalar rather than
+		// fabricate enum members that aren't actually known.
+		fmt.Fprintf(&b.buf, "scalar %s\n\n", enum.Name)
+	}
+
+	for _, msg := range sd.Messages {
+		if err := b.writeMessageType(msg); err != nil {
+			return "", errors.Wrapf(err, "cannot render message %q", msg.Name)
+		}
+	}
+
+	b.writeMapEntryTypes()
+
+	if sd.Service != nil {
+		if err := b.writeRootTypes(sd.Service); err != nil {
+			return "", errors.Wrapf(err, "cannot render service %q", sd.Service.Name)
+		}
+	}
+
+	// Input types are written last, once writeRootTypes has had a chance to
+	// register one for every request message actually used as an argument.
+	b.writeInputTypes()
+
+	return b.buf.String(), nil
+}
+
+func (b *schemaBuilder) writeMessageType(msg *svcdef.Message) error {
+	fmt.Fprintf(&b.buf, "type %s {\n", msg.Name)
+	if err := b.writeFields(&b.buf, msg); err != nil {
+		return err
+	}
+	b.buf.WriteString("}\n\n")
+	return nil
+}
+
+// writeFields writes one GraphQL field line per field of msg to w - for a
+// oneof field, one line per variant instead, since a oneof has no single
+// GraphQL type. Shared by writeMessageType and inputTypeName, which differ
+// only in the header/footer wrapped around the same field listing.
+func (b *schemaBuilder) writeFields(w io.Writer, msg *svcdef.Message) error {
+	for _, f := range msg.Fields {
+		if f.Type.Oneof != nil {
+			for _, v := range f.Type.Oneof.Variants {
+				gqlType, err := b.graphQLType(v.FieldType)
+				if err != nil {
+					return errors.Wrapf(err, "oneof variant %q of field %q", v.Name, f.Name)
+				}
+				fmt.Fprintf(w, "  %s: %s\n", lowerFirst(v.Name), gqlType)
+			}
+			continue
+		}
+		gqlType, err := b.graphQLType(f.Type)
+		if err != nil {
+			return errors.Wrapf(err, "field %q of message %q", f.Name, msg.Name)
+		}
+		fmt.Fprintf(w, "  %s: %s\n", lowerFirst(f.Name), gqlType)
+	}
+	return nil
+}
+
+// inputTypeName returns the name of the `input` type representing ft's
+// message, registering one in b.inputs on first use so it's only defined
+// once no matter how many methods take the same request type.
+func (b *schemaBuilder) inputTypeName(ft *svcdef.FieldType) (string, error) {
+	if ft.Message == nil {
+		return "", errors.Errorf("cannot generate a GraphQL input type for unresolved request type %q", ft.Name)
+	}
+	name := ft.Message.Name + "Input"
+	if _, ok := b.inputs[name]; !ok {
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "input %s {\n", name)
+		if err := b.writeFields(&buf, ft.Message); err != nil {
+			return "", err
+		}
+		buf.WriteString("}\n\n")
+		b.inputs[name] = buf.String()
+	}
+	return name, nil
+}
+
+// writeInputTypes writes out every "Input" type definition accumulated in
+// b.inputs so far, sorted by name so the output is deterministic.
+func (b *schemaBuilder) writeInputTypes() {
+	names := make([]string, 0, len(b.inputs))
+	for name := range b.inputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.buf.WriteString(b.inputs[name])
+	}
+}
+
+// graphQLType maps a svcdef.FieldType to the GraphQL type that represents it,
+// registering a "MapEntry" type in b.entries for any map-typed field found
+// along the way.
+func (b *schemaBuilder) graphQLType(ft *svcdef.FieldType) (string, error) {
+	var name string
+	switch {
+	case ft.Map != nil:
+		keyType, err := b.graphQLType(ft.Map.KeyType)
+		if err != nil {
+			return "", err
+		}
+		valType, err := b.graphQLType(ft.Map.ValueType)
+		if err != nil {
+			return "", err
+		}
+		entryName := keyType + valType + "MapEntry"
+		if _, ok := b.entries[entryName]; !ok {
+			b.entries[entryName] = fmt.Sprintf(
+				"type %s {\n  key: %s\n  value: %s\n}\n\n", entryName, keyType, valType)
+		}
+		return fmt.Sprintf("[%s]", entryName), nil
+	case ft.Enum != nil:
+		name = ft.Enum.Name
+	case ft.Message != nil:
+		name = ft.Message.Name
+	default:
+		if ft.Name == "byte" && ft.ArrayType {
+			// A proto bytes field is a Go []byte: one String, not a list.
+			return "String", nil
+		}
+		gql, ok := scalarTypes[ft.Name]
+		if !ok {
+			return "", errors.Errorf("no GraphQL scalar mapping for proto type %q", ft.Name)
+		}
+		name = gql
+	}
+	if ft.ArrayType {
+		name = "[" + name + "]"
+	}
+	return name, nil
+}
+
+// writeMapEntryTypes writes out every "MapEntry" type definition accumulated
+// in b.entries so far, one per distinct K/V pairing, sorted by name so the
+// output is deterministic across runs.
+func (b *schemaBuilder) writeMapEntryTypes() {
+	names := make([]string, 0, len(b.entries))
+	for name := range b.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.buf.WriteString(b.entries[name])
+	}
+}
+
+func (b *schemaBuilder) writeRootTypes(svc *svcdef.Service) error {
+	var queries, mutations, subs []string
+	for _, m := range svc.Methods {
+		if m.StreamKind == svcdef.ClientStream || m.StreamKind == svcdef.BidiStream {
+			// GraphQL has no client-streaming or bidirectional-streaming
+			// analogue; cleanly skip these methods rather than misrepresent
+			// them.
+			continue
+		}
+
+		argType, err := b.inputTypeName(m.RequestType)
+		if err != nil {
+			return errors.Wrapf(err, "method %q request type", m.Name)
+		}
+		retType, err := b.graphQLType(m.ResponseType)
+		if err != nil {
+			return errors.Wrapf(err, "method %q response type", m.Name)
+		}
+		field := fmt.Sprintf("  %s(input: %s): %s", lowerFirst(m.Name), argType, retType)
+
+		switch {
+		case m.StreamKind == svcdef.ServerStream:
+			subs = append(subs, field)
+		case isMutation(m):
+			mutations = append(mutations, field)
+		default:
+			queries = append(queries, field)
+		}
+	}
+
+	b.writeRootType("Query", queries)
+	b.writeRootType("Mutation", mutations)
+	b.writeRootType("Subscription", subs)
+	return nil
+}
+
+func (b *schemaBuilder) writeRootType(name string, fields []string) {
+	if len(fields) == 0 {
+		return
+	}
+	fmt.Fprintf(&b.buf, "type %s {\n%s\n}\n\n", name, strings.Join(fields, "\n"))
+}
+
+func isMutation(m *svcdef.ServiceMethod) bool {
+	if len(m.Bindings) == 0 {
+		return false
+	}
+	return mutationVerbs[strings.ToUpper(m.Bindings[0].Verb)]
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+// sanitizeIdent returns name with every rune that can't appear in a Go
+// identifier replaced with "_", so that a package path segment like
+// "foo-service" - a common service-directory convention, including in this
+// repo's own examples - can be used as an import alias instead of producing
+// unparseable Go source.
+func sanitizeIdent(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case unicode.IsLetter(r) || r == '_':
+			b.WriteRune(r)
+		case unicode.IsDigit(r) && i > 0:
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
+
+// Resolvers renders Go resolver stub source for sd's service: a
+// self-contained `Resolver` struct wrapping the service's go-kit Endpoints
+// (imported from svcImportPath), a `NewResolver` constructor, and one
+// resolver method per unary or server-streaming ServiceMethod, taking and
+// returning the service's own request/response pointer types and delegating
+// to the matching endpoint. Client- and bidirectional-streaming methods get no
+// resolver.
+// prev should be the previously rendered output of Resolvers for this
+// service, or "" on a first run; any text a user wrote between a
+// `// truss:resolver:begin NAME` / `// truss:resolver:end` marker pair in
+// prev is preserved verbatim.
+func Resolvers(sd *svcdef.Svcdef, pkgName, svcImportPath, prev string) (string, error) {
+	if sd.Service == nil {
+		return "", errors.New("svcdef has no Service to generate resolvers for")
+	}
+	preserved := parsePreservedBlocks(prev)
+	svcAlias := sanitizeIdent(path.Base(svcImportPath))
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "package %s\n\n", pkgName)
+	fmt.Fprintf(&buf, "import (\n\t\"context\"\n\n\t%s %q\n)\n\n", svcAlias, svcImportPath)
+
+	fmt.Fprintf(&buf, "// Resolver implements the GraphQL resolvers for the %s service,\n", sd.Service.Name)
+	buf.WriteString("// delegating to an existing go-kit Endpoints value.\n")
+	buf.WriteString("type Resolver struct {\n")
+	fmt.Fprintf(&buf, "\tendpoints %s.Endpoints\n", svcAlias)
+	buf.WriteString("}\n\n")
+
+	buf.WriteString("// NewResolver returns a Resolver that delegates to endpoints.\n")
+	fmt.Fprintf(&buf, "func NewResolver(endpoints %s.Endpoints) *Resolver {\n", svcAlias)
+	buf.WriteString("\treturn &Resolver{endpoints: endpoints}\n}\n\n")
+
+	for _, m := range sd.Service.Methods {
+		if m.StreamKind == svcdef.ClientStream || m.StreamKind == svcdef.BidiStream {
+			continue
+		}
+		name := "Resolve" + m.Name
+		fmt.Fprintf(&buf, "func (r *Resolver) %s(ctx context.Context, input *%s.%s) (*%s.%s, error) {\n",
+			name, svcAlias, m.RequestType.Name, svcAlias, m.ResponseType.Name)
+		buf.WriteString("\t// truss:resolver:begin " + name + "\n")
+		if body, ok := preserved[name]; ok {
+			buf.WriteString(body)
+		} else {
+			fmt.Fprintf(&buf, "\tresp, err := r.endpoints.%s(ctx, input)\n", m.Name)
+			buf.WriteString("\tif err != nil {\n\t\treturn nil, err\n\t}\n")
+			fmt.Fprintf(&buf, "\treturn resp.(*%s.%s), nil\n", svcAlias, m.ResponseType.Name)
+		}
+		buf.WriteString("\t// truss:resolver:end " + name + "\n")
+		buf.WriteString("}\n\n")
+	}
+
+	return buf.String(), nil
+}
+
+// parsePreservedBlocks extracts the body previously written between each
+// `// truss:resolver:begin NAME` / `// truss:resolver:end` marker pair found
+// in prev, keyed by NAME.
+func parsePreservedBlocks(prev string) map[string]string {
+	rv := map[string]string{}
+	if prev == "" {
+		return rv
+	}
+
+	var name string
+	var body []string
+	inBlock := false
+	for _, line := range strings.Split(prev, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "// truss:resolver:begin "):
+			name = strings.TrimPrefix(trimmed, "// truss:resolver:begin ")
+			body = nil
+			inBlock = true
+		case strings.HasPrefix(trimmed, "// truss:resolver:end"):
+			if inBlock {
+				rv[name] = strings.Join(body, "\n")
+				if len(body) > 0 {
+					// Keep the end marker on its own line when the body is
+					// written back out.
+					rv[name] += "\n"
+				}
+			}
+			inBlock = false
+		case inBlock:
+			body = append(body, line)
+		}
+	}
+	return rv
+}
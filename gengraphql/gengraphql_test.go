@@ -0,0 +1,214 @@
+package gengraphql
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/TuneLab/go-truss/svcdef"
+)
+
+func TestSchemaMessage(t *testing.T) {
+	sd := &svcdef.Svcdef{
+		Messages: []*svcdef.Message{
+			{
+				Name: "MapTypeRequest",
+				Fields: []*svcdef.Field{
+					{Name: "Key", Type: &svcdef.FieldType{Name: "string"}},
+					{Name: "Count", Type: &svcdef.FieldType{Name: "int64"}},
+				},
+			},
+		},
+	}
+
+	schema, err := Schema(sd)
+	if err != nil {
+		t.Fatalf("Schema returned error: %v", err)
+	}
+	if !strings.Contains(schema, "type MapTypeRequest {") {
+		t.Errorf("expected schema to declare MapTypeRequest, got:\n%s", schema)
+	}
+	if !strings.Contains(schema, "key: String") {
+		t.Errorf("expected schema to map string field, got:\n%s", schema)
+	}
+	if !strings.Contains(schema, "count: String") {
+		t.Errorf("expected schema to map int64 field to String, got:\n%s", schema)
+	}
+}
+
+func TestSchemaMapFieldDeclaresEntryType(t *testing.T) {
+	sd := &svcdef.Svcdef{
+		Messages: []*svcdef.Message{
+			{
+				Name: "Holder",
+				Fields: []*svcdef.Field{
+					{
+						Name: "Counts",
+						Type: &svcdef.FieldType{
+							Map: &svcdef.Map{
+								KeyType:   &svcdef.FieldType{Name: "string"},
+								ValueType: &svcdef.FieldType{Name: "int64"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	schema, err := Schema(sd)
+	if err != nil {
+		t.Fatalf("Schema returned error: %v", err)
+	}
+	if !strings.Contains(schema, "counts: [StringStringMapEntry]") {
+		t.Errorf("expected map field to reference StringStringMapEntry, got:\n%s", schema)
+	}
+	if !strings.Contains(schema, "type StringStringMapEntry {\n  key: String\n  value: String\n}") {
+		t.Errorf("expected a StringStringMapEntry type definition, got:\n%s", schema)
+	}
+}
+
+func TestSchemaQueryAndMutation(t *testing.T) {
+	reqMsg := &svcdef.Message{Name: "Req"}
+	respMsg := &svcdef.Message{Name: "Resp"}
+	reqType := &svcdef.FieldType{Name: "Req", Message: reqMsg, StarExpr: true}
+	respType := &svcdef.FieldType{Name: "Resp", Message: respMsg, StarExpr: true}
+	sd := &svcdef.Svcdef{
+		Service: &svcdef.Service{
+			Name: "Foo",
+			Methods: []*svcdef.ServiceMethod{
+				{Name: "GetThing", RequestType: reqType, ResponseType: respType},
+				{
+					Name: "CreateThing", RequestType: reqType, ResponseType: respType,
+					Bindings: []*svcdef.HTTPBinding{{Verb: "POST"}},
+				},
+				{
+					Name: "WatchThing", RequestType: reqType, ResponseType: respType,
+					StreamKind: svcdef.ServerStream,
+				},
+				{
+					Name: "SendThings", RequestType: reqType, ResponseType: respType,
+					StreamKind: svcdef.ClientStream,
+				},
+			},
+		},
+	}
+
+	schema, err := Schema(sd)
+	if err != nil {
+		t.Fatalf("Schema returned error: %v", err)
+	}
+	if !strings.Contains(schema, "type Query {\n  getThing(input: ReqInput): Resp\n}") {
+		t.Errorf("expected Query type with getThing, got:\n%s", schema)
+	}
+	if !strings.Contains(schema, "type Mutation {\n  createThing(input: ReqInput): Resp\n}") {
+		t.Errorf("expected Mutation type with createThing, got:\n%s", schema)
+	}
+	if !strings.Contains(schema, "type Subscription {\n  watchThing(input: ReqInput): Resp\n}") {
+		t.Errorf("expected Subscription type with watchThing, got:\n%s", schema)
+	}
+	if !strings.Contains(schema, "input ReqInput {\n}") {
+		t.Errorf("expected a companion ReqInput type for the Req request message, got:\n%s", schema)
+	}
+	if strings.Contains(schema, "sendThings") {
+		t.Errorf("expected client-streaming method to be skipped, got:\n%s", schema)
+	}
+}
+
+func TestSchemaRequestMessageGetsInputType(t *testing.T) {
+	reqMsg := &svcdef.Message{
+		Name: "GetThingRequest",
+		Fields: []*svcdef.Field{
+			{Name: "Id", Type: &svcdef.FieldType{Name: "string"}},
+		},
+	}
+	respMsg := &svcdef.Message{Name: "GetThingResponse"}
+	sd := &svcdef.Svcdef{
+		Messages: []*svcdef.Message{reqMsg, respMsg},
+		Service: &svcdef.Service{
+			Name: "Foo",
+			Methods: []*svcdef.ServiceMethod{
+				{
+					Name:         "GetThing",
+					RequestType:  &svcdef.FieldType{Name: "GetThingRequest", Message: reqMsg, StarExpr: true},
+					ResponseType: &svcdef.FieldType{Name: "GetThingResponse", Message: respMsg, StarExpr: true},
+				},
+			},
+		},
+	}
+
+	schema, err := Schema(sd)
+	if err != nil {
+		t.Fatalf("Schema returned error: %v", err)
+	}
+	if !strings.Contains(schema, "type GetThingRequest {\n  id: String\n}") {
+		t.Errorf("expected GetThingRequest to still be rendered as an object type, got:\n%s", schema)
+	}
+	if !strings.Contains(schema, "input GetThingRequestInput {\n  id: String\n}") {
+		t.Errorf("expected a GetThingRequestInput input type, got:\n%s", schema)
+	}
+	if !strings.Contains(schema, "getThing(input: GetThingRequestInput): GetThingResponse") {
+		t.Errorf("expected getThing to take the input type, not the object type, got:\n%s", schema)
+	}
+}
+
+func TestResolversPreservesUserEdits(t *testing.T) {
+	sd := &svcdef.Svcdef{
+		Service: &svcdef.Service{
+			Name: "Foo",
+			Methods: []*svcdef.ServiceMethod{
+				{
+					Name:         "GetThing",
+					RequestType:  &svcdef.FieldType{Name: "Req"},
+					ResponseType: &svcdef.FieldType{Name: "Resp"},
+				},
+			},
+		},
+	}
+
+	first, err := Resolvers(sd, "resolvers", "github.com/TuneLab/go-truss/example/foo-service", "")
+	if err != nil {
+		t.Fatalf("Resolvers returned error: %v", err)
+	}
+	if !strings.Contains(first, "r.endpoints.GetThing(ctx, input)") {
+		t.Errorf("expected generated stub body on first run, got:\n%s", first)
+	}
+	if !strings.Contains(first, "type Resolver struct {") {
+		t.Errorf("expected a self-contained Resolver type, got:\n%s", first)
+	}
+	if !strings.Contains(first, "foo_service.Endpoints") {
+		t.Errorf("expected Resolver to be backed by the service's Endpoints type, got:\n%s", first)
+	}
+	if !strings.Contains(first, "func (r *Resolver) ResolveGetThing(ctx context.Context, input *foo_service.Req) (*foo_service.Resp, error) {") {
+		t.Errorf("expected the resolver method to take/return the service's own pointer types, got:\n%s", first)
+	}
+	if !strings.Contains(first, "return resp.(*foo_service.Resp), nil") {
+		t.Errorf("expected the generated stub to assert the endpoint's response to its pointer type, got:\n%s", first)
+	}
+
+	edited := strings.Replace(first,
+		"\tresp, err := r.endpoints.GetThing(ctx, input)\n\tif err != nil {\n\t\treturn nil, err\n\t}\n\treturn resp.(*foo_service.Resp), nil\n",
+		"\treturn r.cache.GetThingCached(ctx, input)\n",
+		1)
+
+	second, err := Resolvers(sd, "resolvers", "github.com/TuneLab/go-truss/example/foo-service", edited)
+	if err != nil {
+		t.Fatalf("Resolvers returned error: %v", err)
+	}
+	if !strings.Contains(second, "r.cache.GetThingCached(ctx, input)") {
+		t.Errorf("expected user edit to survive regeneration, got:\n%s", second)
+	}
+}
+
+func TestSanitizeIdent(t *testing.T) {
+	cases := map[string]string{
+		"fooservice":  "fooservice",
+		"foo-service": "foo_service",
+		"foo.service": "foo_service",
+		"2fast":       "_fast",
+	}
+	for in, want := range cases {
+		if got := sanitizeIdent(in); got != want {
+			t.Errorf("sanitizeIdent(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -11,8 +11,8 @@ Additionally, since svcdef only parses Go code generated by protoc-gen-go, all
 methods accept only ast types with structures created by protoc-gen-go. See
 NewTYPE functions such as NewMap for details on the relevant conventions.
 
-Note that svcdef does not support embedding sub-fields of nested messages into
-the path of an HTTP annotation.
+An HTTP annotation's path may embed a sub-field of a nested message, e.g.
+`{user.address.zip}`; see HTTPParameter.FieldPath and ResolveFieldPath.
 */
 package svcdef
 
@@ -23,7 +23,9 @@ import (
 	"go/parser"
 	"go/token"
 	"io"
+	"io/ioutil"
 	"reflect"
+	"regexp"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
@@ -48,12 +50,30 @@ type Svcdef struct {
 type Message struct {
 	Name   string
 	Fields []*Field
+	// Oneofs contains one entry for each `oneof` field group declared
+	// directly on this Message.
+	Oneofs []*Oneof
 }
 
 type Enum struct {
 	Name string
 }
 
+// Oneof represents a protobuf `oneof` field grouping: a tagged union where
+// at most one of several Variants is set at a time.
+type Oneof struct {
+	Name     string
+	Variants []*OneofVariant
+}
+
+// OneofVariant represents one of the possible types that may be assigned to
+// a Oneof, corresponding to one of the fields declared inside a `oneof`
+// block in the source .proto file.
+type OneofVariant struct {
+	Name      string
+	FieldType *FieldType
+}
+
 type Map struct {
 	// KeyType will always be a basetype, e.g. string, int64, etc.
 	KeyType   *FieldType
@@ -65,11 +85,46 @@ type Service struct {
 	Methods []*ServiceMethod
 }
 
+// StreamKind describes whether a ServiceMethod is a plain request/response
+// RPC or one of the three flavors of gRPC streaming RPC.
+type StreamKind int
+
+const (
+	// UnaryStream is a normal RPC; one request, one response.
+	UnaryStream StreamKind = iota
+	// ServerStream is an RPC where the server sends back a stream of
+	// responses for a single request.
+	ServerStream
+	// ClientStream is an RPC where the client sends a stream of requests
+	// before receiving a single response.
+	ClientStream
+	// BidiStream is an RPC where both client and server stream messages to
+	// each other independently.
+	BidiStream
+)
+
+func (sk StreamKind) String() string {
+	switch sk {
+	case ServerStream:
+		return "ServerStream"
+	case ClientStream:
+		return "ClientStream"
+	case BidiStream:
+		return "BidiStream"
+	default:
+		return "Unary"
+	}
+}
+
 type ServiceMethod struct {
 	Name         string
 	SnakeName 	 string
 	RequestType  *FieldType
 	ResponseType *FieldType
+	// StreamKind indicates whether this method is unary or, if not, which
+	// kind of streaming RPC it is. Non-unary methods have no HTTP bindings;
+	// Bindings will be empty for them.
+	StreamKind StreamKind
 	// Bindings contains information for mapping http paths and paramters onto
 	// the fields of this ServiceMethods RequestType.
 	Bindings []*HTTPBinding
@@ -101,6 +156,10 @@ type FieldType struct {
 	// Map contains a pointer to the Map type this FieldType represents, if
 	// this FieldType represents a Map. If not, Map is nil.
 	Map *Map
+	// Oneof contains a pointer to the Oneof type this FieldType represents,
+	// if this FieldType represents a protobuf `oneof` field group. If not,
+	// Oneof is nil.
+	Oneof *Oneof
 	// StarExpr is True if this FieldType represents a pointer to a type.
 	StarExpr bool
 	// ArrayType is True if this FieldType represents a slice of a type.
@@ -123,10 +182,68 @@ type HTTPBinding struct {
 type HTTPParameter struct {
 	// Field points to a Field on the Parent service methods "RequestType".
 	Field *Field
+	// FieldPath contains the ordered chain of Fields from the parent service
+	// method's RequestType down to Field, inclusive. For a top-level field
+	// (the common case), FieldPath has exactly one element, equal to Field.
+	// For an HTTP annotation naming a nested sub-field, e.g.
+	// `{user.address.zip}`, FieldPath holds [user, address, zip] and Field
+	// is the leaf, zip. See ResolveFieldPath.
+	FieldPath []*Field
 	// Location will be either "body", "path", or "query"
 	Location string
 }
 
+// ResolveFieldPath resolves a dotted HTTP annotation path segment, such as
+// "user.address.zip", against root (typically a ServiceMethod's
+// RequestType.Message), returning the ordered chain of Fields from root down
+// to the leaf. Every non-leaf segment must name a nested Message field;
+// the leaf must name a scalar or Enum field; a path resolving to a Message
+// or Map leaf, or naming a field that doesn't exist, is reported as a
+// LocationError pointing at pos in the .proto source identified by info.
+func ResolveFieldPath(root *Message, path string, info *DebugInfo, pos token.Pos) ([]*Field, error) {
+	segments := strings.Split(path, ".")
+	msg := root
+	chain := make([]*Field, 0, len(segments))
+	for i, seg := range segments {
+		field := fieldByName(msg, seg)
+		if field == nil {
+			return nil, NewLocationError(fmt.Sprintf("no field %q on message "+
+				"%q while resolving HTTP path %q", seg, msg.Name, path),
+				info.Path, info.Position(pos))
+		}
+		chain = append(chain, field)
+
+		if i == len(segments)-1 {
+			if field.Type.Message != nil || field.Type.Map != nil {
+				return nil, NewLocationError(fmt.Sprintf("HTTP path %q names "+
+					"field %q of message %q, which is not a scalar or enum",
+					path, field.Name, msg.Name), info.Path, info.Position(pos))
+			}
+			return chain, nil
+		}
+
+		if field.Type.Message == nil {
+			return nil, NewLocationError(fmt.Sprintf("HTTP path segment %q of "+
+				"%q names field %q of message %q, which is not a nested "+
+				"message", seg, path, field.Name, msg.Name),
+				info.Path, info.Position(pos))
+		}
+		msg = field.Type.Message
+	}
+	return chain, nil
+}
+
+// fieldByName returns the Field on msg whose .proto field name or Go field
+// name matches name, or nil if there is none.
+func fieldByName(msg *Message, name string) *Field {
+	for _, f := range msg.Fields {
+		if f.PBFieldName == name || f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
 func retrieveTypeSpecs(f *ast.File) ([]*ast.TypeSpec, error) {
 	var rv []*ast.TypeSpec
 	for _, dec := range f.Decls {
@@ -188,6 +305,236 @@ func (le LocationError) Location() string {
 	return le.Position
 }
 
+// resolveTypes binds each FieldType's Message/Enum pointer to the actual
+// Message/Enum it names, by walking every Field of every Message (including
+// their Oneof variants' FieldTypes) as well as each ServiceMethod's
+// RequestType and ResponseType. A map-typed FieldType is walked into its own
+// KeyType and ValueType; once ValueType.Message is bound, ResolveMapEntry is
+// applied to it, unwrapping it in place if it turns out to be a synthetic
+// map-entry message wrapping a nested map or repeated value.
+func resolveTypes(sd *Svcdef) {
+	messages := make(map[string]*Message, len(sd.Messages))
+	for _, m := range sd.Messages {
+		messages[m.Name] = m
+	}
+	enums := make(map[string]*Enum, len(sd.Enums))
+	for _, e := range sd.Enums {
+		enums[e.Name] = e
+	}
+
+	var bind func(ft *FieldType)
+	bind = func(ft *FieldType) {
+		if ft == nil {
+			return
+		}
+		if ft.Map != nil {
+			bind(ft.Map.KeyType)
+			bind(ft.Map.ValueType)
+			ResolveMapEntry(ft.Map.ValueType)
+			// The promoted type may not have been bound yet if the entry
+			// message comes later in sd.Messages.
+			bind(ft.Map.ValueType)
+			return
+		}
+		if msg, ok := messages[ft.Name]; ok {
+			ft.Message = msg
+			return
+		}
+		if enum, ok := enums[ft.Name]; ok {
+			ft.Enum = enum
+		}
+	}
+
+	for _, msg := range sd.Messages {
+		for _, f := range msg.Fields {
+			bind(f.Type)
+		}
+		for _, oneof := range msg.Oneofs {
+			for _, v := range oneof.Variants {
+				bind(v.FieldType)
+			}
+		}
+	}
+
+	if sd.Service == nil {
+		return
+	}
+	for _, m := range sd.Service.Methods {
+		bind(m.RequestType)
+		bind(m.ResponseType)
+	}
+}
+
+// httpVerbs are the google.api.http binding keys that name an HTTP verb,
+// mapped to the uppercase verb an HTTPBinding.Verb holds.
+var httpVerbs = map[string]string{
+	"get":    "GET",
+	"post":   "POST",
+	"put":    "PUT",
+	"delete": "DELETE",
+	"patch":  "PATCH",
+}
+
+var (
+	rpcRe     = regexp.MustCompile(`rpc\s+(\w+)\s*\(`)
+	httpOptRe = regexp.MustCompile(`option\s*\(google\.api\.http\)\s*=\s*\{`)
+	bindingRe = regexp.MustCompile(`(get|post|put|delete|patch)\s*:\s*"([^"]*)"`)
+	bodyRe    = regexp.MustCompile(`body\s*:\s*"([^"]*)"`)
+	pathVarRe = regexp.MustCompile(`\{([^}=]+)(?:=[^}]*)?\}`)
+)
+
+// findBalanced returns the index, within s, of the "}" matching the "{" at
+// s[open], ignoring any "{"/"}" that appear inside a quoted string. It
+// returns -1 if s[open] isn't "{" or the brace is never closed.
+func findBalanced(s string, open int) int {
+	if open >= len(s) || s[open] != '{' {
+		return -1
+	}
+	depth := 0
+	inQuote := false
+	for i := open; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' && (i == 0 || s[i-1] != '\\'):
+			inQuote = !inQuote
+		case inQuote:
+			continue
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// consolidateHTTP scans protoFiles for `rpc` methods annotated with a
+// `google.api.http` option, and for each one found, populates the matching
+// ServiceMethod's Bindings with the HTTP verb, path, and per-field parameter
+// locations the annotation describes. A method with no such annotation, one
+// absent from sd.Service, or a streaming (non-unary) method is left with no
+// Bindings.
+//
+// This is a deliberately small, regexp-based scanner rather than a full
+// .proto parser: it only needs to recover the handful of fields an HTTP
+// annotation can set, not represent the .proto file in general.
+func consolidateHTTP(sd *Svcdef, protoFiles map[string]io.Reader) error {
+	if sd.Service == nil {
+		return nil
+	}
+	methods := make(map[string]*ServiceMethod, len(sd.Service.Methods))
+	for _, m := range sd.Service.Methods {
+		if m.StreamKind != UnaryStream {
+			continue
+		}
+		methods[m.Name] = m
+	}
+
+	for path, r := range protoFiles {
+		src, err := ioutil.ReadAll(r)
+		if err != nil {
+			return errors.Wrapf(err, "cannot read proto file %q", path)
+		}
+		text := string(src)
+
+		for _, loc := range rpcRe.FindAllStringSubmatchIndex(text, -1) {
+			name := text[loc[2]:loc[3]]
+			method, ok := methods[name]
+			if !ok {
+				continue
+			}
+
+			declEnd := strings.IndexAny(text[loc[1]:], ";{")
+			if declEnd == -1 {
+				continue
+			}
+			declEnd += loc[1]
+			if text[declEnd] == ';' {
+				// rpc declared with a bare `;`, e.g. `rpc Foo(Req) returns (Resp);`
+				continue
+			}
+
+			bodyStart := findBalanced(text, declEnd)
+			if bodyStart == -1 {
+				continue
+			}
+			body := text[loc[1]:bodyStart]
+
+			optLoc := httpOptRe.FindStringIndex(body)
+			if optLoc == nil {
+				continue
+			}
+			optEnd := findBalanced(body, optLoc[1]-1)
+			if optEnd == -1 {
+				return errors.Errorf("unterminated google.api.http option on rpc %q in %q", name, path)
+			}
+			opt := body[optLoc[1]:optEnd]
+
+			bindingMatch := bindingRe.FindStringSubmatch(opt)
+			if bindingMatch == nil {
+				continue
+			}
+			binding, err := newHTTPBinding(method, httpVerbs[bindingMatch[1]], bindingMatch[2], opt, &DebugInfo{Path: path})
+			if err != nil {
+				return err
+			}
+			method.Bindings = append(method.Bindings, binding)
+		}
+	}
+	return nil
+}
+
+// newHTTPBinding builds an HTTPBinding for method from the parsed contents of
+// a single google.api.http option: verb and pbPath are its chosen binding
+// (e.g. the value of its "get" key), and opt is the option's full body, used
+// to look for a "body" key. Every field of method.RequestType.Message is
+// classified into exactly one HTTPParameter, located in "path" if it's named
+// by a {segment} of pbPath, "body" if opt names it (or names "*") as the
+// body, or "query" otherwise.
+func newHTTPBinding(method *ServiceMethod, verb, pbPath, opt string, info *DebugInfo) (*HTTPBinding, error) {
+	root := method.RequestType.Message
+	binding := &HTTPBinding{Verb: verb, Path: pbPath}
+
+	inPath := map[*Field]bool{}
+	for _, m := range pathVarRe.FindAllStringSubmatch(pbPath, -1) {
+		chain, err := ResolveFieldPath(root, strings.TrimSpace(m[1]), info, token.NoPos)
+		if err != nil {
+			return nil, errors.Wrapf(err, "method %q", method.Name)
+		}
+		leaf := chain[len(chain)-1]
+		inPath[leaf] = true
+		binding.Params = append(binding.Params, &HTTPParameter{
+			Field:     leaf,
+			FieldPath: chain,
+			Location:  "path",
+		})
+	}
+
+	bodyField := ""
+	if m := bodyRe.FindStringSubmatch(opt); m != nil {
+		bodyField = m[1]
+	}
+
+	for _, f := range root.Fields {
+		if inPath[f] {
+			continue
+		}
+		location := "query"
+		if bodyField == "*" || bodyField == f.PBFieldName {
+			location = "body"
+		}
+		binding.Params = append(binding.Params, &HTTPParameter{
+			Field:     f,
+			FieldPath: []*Field{f},
+			Location:  location,
+		})
+	}
+
+	return binding, nil
+}
+
 // New creates a Svcdef by parsing the provided Go and Protobuf source files to
 // derive type information, gRPC service data, and HTTP annotations.
 func New(goFiles map[string]io.Reader, protoFiles map[string]io.Reader) (*Svcdef, error) {
@@ -209,6 +556,40 @@ func New(goFiles map[string]io.Reader, protoFiles map[string]io.Reader) (*Svcdef
 		if err != nil {
 			return nil, errors.Wrap(err, "cannot retrive type specs")
 		}
+
+		// sentinelMethods maps the name of a oneof wrapper struct (e.g.
+		// "Msg_FieldA") to the name of the oneof interface it implements
+		// (e.g. "isMsg_Field"), found via the unexported, argument-less
+		// sentinel method protoc-gen-go generates for it:
+		//
+		//     func (*Msg_FieldA) isMsg_Field() {}
+		sentinelMethods := map[string]string{}
+		for _, dec := range fileAst.Decls {
+			fd, ok := dec.(*ast.FuncDecl)
+			if !ok || fd.Recv == nil || len(fd.Recv.List) == 0 {
+				continue
+			}
+			if fd.Name.IsExported() || len(fd.Type.Params.List) != 0 || fd.Type.Results != nil {
+				continue
+			}
+			recv, ok := identName(fd.Recv.List[0].Type)
+			if !ok {
+				continue
+			}
+			sentinelMethods[recv.Name] = fd.Name.Name
+		}
+
+		// oneofIfaces maps the name of a oneof wrapper interface (e.g.
+		// "isMsg_Field") to the Oneof it represents. It's fully populated by
+		// the first pass below, before the second pass looks any wrapper
+		// struct up in it, so variant association doesn't depend on a oneof
+		// interface appearing before its wrapper structs in the source file.
+		oneofIfaces := map[string]*Oneof{}
+
+		// First pass: Enums and interfaces. Interfaces are handled in this
+		// pass, rather than alongside structs, because a oneof wrapper
+		// struct (handled in the second pass, below) needs oneofIfaces fully
+		// populated to find the Oneof its sentinel method points at.
 		for _, t := range typespecs {
 			switch typdf := t.Type.(type) {
 			case *ast.Ident:
@@ -219,37 +600,80 @@ func New(goFiles map[string]io.Reader, protoFiles map[string]io.Reader) (*Svcdef
 					}
 					rv.Enums = append(rv.Enums, nenm)
 				}
-			case *ast.StructType:
-				// Non-exported structs do not represent types
-				if !t.Name.IsExported() {
-					break
-				}
-				nmsg, err := NewMessage(t)
-				if err != nil {
-					return nil, errors.Wrapf(err, "error parsing message %q", t.Name.Name)
-				}
-				rv.Messages = append(rv.Messages, nmsg)
 			case *ast.InterfaceType:
 				// Each service will have two interfaces ("{SVCNAME}Server" and
 				// "{SVCNAME}Client") each containing the same information that we
 				// care about, but structured a bit differently. Additionally,
-				// oneof fields generate an interface which is not a service - so
-				// for simplicity, only process the "Server" interface.
+				// a streaming method generates its own per-method helper
+				// interface, "{SVCNAME}_{METHOD}Server"/"...Client", which also
+				// ends in "Server"/"Client" but is not itself a service - it's
+				// resolved on demand by NewServiceMethod (via the typespecs
+				// it's handed), so there's nothing to do with it here. Unlike a
+				// real service interface, these are always exported and
+				// contain an underscore; a oneof wrapper interface also
+				// contains an underscore, but is never exported, so it isn't
+				// mistaken for one of these.
+				if isStreamHelperInterface(t) {
+					break
+				}
 				if !strings.HasSuffix(t.Name.Name, "Server") {
 					if !strings.HasSuffix(t.Name.Name, "Client") {
-						// This interface isn't either Server or Client; it may be a oneof
-						// field, which isn't currently supported.  Warn the user and skip.
+						if oneof, ok := NewOneof(t); ok {
+							oneofIfaces[t.Name.Name] = oneof
+							break
+						}
+						// This interface isn't Server, Client, or a oneof wrapper.
+						// Warn the user and skip.
 						log.Warnf("Unexpected interface %s found; skipping", t.Name.Name)
 					}
 					break
 				}
-				nsvc, err := NewService(t, debugInfo)
+				nsvc, err := NewService(t, debugInfo, typespecs)
 				if err != nil {
 					return nil, errors.Wrapf(err, "error parsing service %q", t.Name.Name)
 				}
 				rv.Service = nsvc
 			}
 		}
+
+		// Second pass: structs, now that oneofIfaces is fully populated.
+		for _, t := range typespecs {
+			if _, ok := t.Type.(*ast.StructType); !ok {
+				continue
+			}
+			// Non-exported structs do not represent types
+			if !t.Name.IsExported() {
+				continue
+			}
+			if ifaceName, ok := sentinelMethods[t.Name.Name]; ok {
+				if oneof, ok := oneofIfaces[ifaceName]; ok {
+					variant, err := NewOneofVariant(t)
+					if err != nil {
+						return nil, errors.Wrapf(err, "error parsing oneof variant %q", t.Name.Name)
+					}
+					oneof.Variants = append(oneof.Variants, variant)
+					continue
+				}
+			}
+			nmsg, err := NewMessage(t)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error parsing message %q", t.Name.Name)
+			}
+			rv.Messages = append(rv.Messages, nmsg)
+		}
+
+		// Link each message field whose type is a oneof wrapper interface
+		// back to the Oneof it represents.
+		for _, msg := range rv.Messages {
+			for _, f := range msg.Fields {
+				oneof, ok := oneofIfaces[f.Type.Name]
+				if !ok {
+					continue
+				}
+				f.Type.Oneof = oneof
+				msg.Oneofs = append(msg.Oneofs, oneof)
+			}
+		}
 	}
 	resolveTypes(&rv)
 	err := consolidateHTTP(&rv, protoFiles)
@@ -288,54 +712,195 @@ func NewMessage(m *ast.TypeSpec) (*Message, error) {
 	return rv, nil
 }
 
+// isStreamHelperInterface reports whether t is a `{Svc}_{Method}Server` or
+// `{Svc}_{Method}Client` helper interface, generated by protoc-gen-go for a
+// streaming method, as opposed to the service's own "{Svc}Server"/
+// "{Svc}Client" interface. Both kinds end in "Server"/"Client", but only the
+// per-method helper is both exported and contains an underscore: the
+// service interface's name never does, and a oneof wrapper interface
+// (which also contains an underscore) is never exported.
+func isStreamHelperInterface(t *ast.TypeSpec) bool {
+	name := t.Name.Name
+	return t.Name.IsExported() && strings.Contains(name, "_") &&
+		(strings.HasSuffix(name, "Server") || strings.HasSuffix(name, "Client"))
+}
+
+// NewOneof returns a new, empty Oneof (with no Variants yet) derived from an
+// *ast.TypeSpec, if that TypeSpec matches the shape protoc-gen-go generates
+// for a oneof wrapper interface:
+//
+//     type isMsg_Field interface {
+//         isMsg_Field()
+//     }
+//
+// That is, an unexported interface declaring exactly one unexported,
+// argument-less, result-less sentinel method of the same name as the
+// interface itself. The ok return value is false if t does not match this
+// shape, in which case t is some other, unrelated interface.
+func NewOneof(t *ast.TypeSpec) (oneof *Oneof, ok bool) {
+	if t.Name.IsExported() {
+		return nil, false
+	}
+	iface, ok := t.Type.(*ast.InterfaceType)
+	if !ok || len(iface.Methods.List) != 1 {
+		return nil, false
+	}
+	meth := iface.Methods.List[0]
+	if len(meth.Names) != 1 || meth.Names[0].Name != t.Name.Name {
+		return nil, false
+	}
+	ft, ok := meth.Type.(*ast.FuncType)
+	if !ok || len(ft.Params.List) != 0 || ft.Results != nil {
+		return nil, false
+	}
+	// The field name this oneof is assigned to is the part of the interface
+	// name following "is{MessageName}_".
+	name := strings.TrimPrefix(t.Name.Name, "is")
+	if idx := strings.LastIndex(name, "_"); idx != -1 {
+		name = name[idx+1:]
+	}
+	return &Oneof{Name: name}, true
+}
+
+// NewOneofVariant returns a new OneofVariant derived from an *ast.TypeSpec
+// with a Type of *ast.StructType, representing one of the wrapper structs
+// protoc-gen-go generates to hold a single possible value of a oneof field,
+// for example:
+//
+//     type Msg_FieldA struct {
+//         FieldA string `protobuf:"bytes,2,opt,name=field_a,oneof"`
+//     }
+func NewOneofVariant(t *ast.TypeSpec) (*OneofVariant, error) {
+	strct := t.Type.(*ast.StructType)
+	if len(strct.Fields.List) != 1 {
+		return nil, NewLocationError(fmt.Sprintf("oneof variant %q does not "+
+			"have exactly one field", t.Name.Name), "", "")
+	}
+	field, err := NewField(strct.Fields.List[0])
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot create field while creating oneof variant %q", t.Name.Name)
+	}
+	return &OneofVariant{
+		Name:      field.Name,
+		FieldType: field.Type,
+	}, nil
+}
+
 // NewMap returns a new Map struct derived from an ast.Expr interface
 // implemented by an *ast.MapType struct. This code cannot accept an arbitrary
 // MapType, only one which follows the conventions of Go code generated by
 // protoc-gen-go. Those conventions are:
 //
 //     1. The KeyType of the *ast.MapType will always be an ast.Ident
-//     2. The ValueType may be an ast.Ident OR an ast.StarExpr -> ast.Ident
+//     2. The ValueType may be any shape NewField's typeFollower recognizes
+//        for a "naked" (non-repeated) field: an ast.Ident (a basetype or
+//        Enum) or an *ast.StarExpr -> ast.Ident (a Message).
 //
 // These rules are a result of the rules for map fields of Protobuf messages,
 // namely that a key may only be represented by a non-float basetype (e.g.
-// int64, string, etc.), and that a value may be either a basetype or a Message
-// type or an Enum type. In the resulting Go code, a basetype will be
-// represented as an ast.Ident, while a key that is a Message or Enum type will
-// be represented as an *ast.StarExpr which references an ast.Ident.
+// int64, string, etc.), and that a value may be either a basetype, a Message
+// type, or an Enum type. In the resulting Go code, a basetype will be
+// represented as an ast.Ident, as will an Enum type, while a value that is a
+// Message type will be represented as an *ast.StarExpr which references an
+// ast.Ident.
+//
+// Protobuf forbids a map value from being itself a map or a repeated field
+// directly; instead, protoc-gen-go generates a synthetic "XXX_Entry" message
+// wrapping the nested map/repeated in a "Value" field, and the outer Go map's
+// value type is an *ast.StarExpr to that synthetic message, indistinguishable
+// at this stage from any other Message-typed value. Once resolveTypes binds
+// ValueType.Message to that synthesized entry message, ResolveMapEntry
+// unwraps it, promoting the entry's "Value" field's own Map/ArrayType/Message
+// up onto ValueType.
 func NewMap(m ast.Expr) (*Map, error) {
 	rv := &Map{
 		KeyType:   &FieldType{},
 		ValueType: &FieldType{},
 	}
 	mp := m.(*ast.MapType)
-	// KeyType will always be an ast.Ident, ValueType may be an ast.Ident or an
-	// ast.StarExpr->ast.Ident
 	key := mp.Key.(*ast.Ident)
 	rv.KeyType.Name = key.Name
-	var keyFollower func(ast.Expr)
-	keyFollower = func(e ast.Expr) {
+
+	var valueFollower func(ast.Expr) error
+	valueFollower = func(e ast.Expr) error {
 		switch ex := e.(type) {
 		case *ast.Ident:
 			rv.ValueType.Name = ex.Name
 		case *ast.StarExpr:
 			rv.ValueType.StarExpr = true
-			keyFollower(ex.X)
+			return valueFollower(ex.X)
+		case *ast.ArrayType:
+			rv.ValueType.ArrayType = true
+			return valueFollower(ex.Elt)
+		case *ast.SelectorExpr:
+			rv.ValueType.Name = ex.Sel.Name
+		case *ast.MapType:
+			nested, err := NewMap(ex)
+			if err != nil {
+				return errors.Wrap(err, "failed to create nested map value")
+			}
+			rv.ValueType.Map = nested
 		}
+		return nil
+	}
+	if err := valueFollower(mp.Value); err != nil {
+		return nil, err
 	}
-	keyFollower(mp.Value)
 
 	return rv, nil
 }
 
+// mapEntryValueField returns msg's "Value" field if msg has the shape
+// protoc-gen-go generates for a synthetic map-entry message - a name of the
+// form "{Parent}_{Field}Entry", and exactly two fields, "Key" and "Value", in
+// that order - or nil if it does not. The name check matters as much as the
+// field shape: a legitimate `map<string, RealMsg>` whose RealMsg happens to
+// declare fields named Key/Value would otherwise be mistaken for a
+// synthetic entry and unwrapped right out of existence.
+func mapEntryValueField(msg *Message) *Field {
+	if !strings.Contains(msg.Name, "_") || !strings.HasSuffix(msg.Name, "Entry") {
+		return nil
+	}
+	if len(msg.Fields) != 2 {
+		return nil
+	}
+	if msg.Fields[0].Name != "Key" || msg.Fields[1].Name != "Value" {
+		return nil
+	}
+	return msg.Fields[1]
+}
+
+// ResolveMapEntry checks whether v's Message has been resolved to a
+// synthetic map-entry message - the wrapper protoc-gen-go generates for a
+// `map<K, V>` field whose V is itself a map or repeated type - and if so,
+// replaces v in place with its "Value" field's own FieldType, so that
+// v.Map / v.ArrayType / v.Message describe the real nested value type
+// instead of the synthetic wrapper message. v is left unchanged if its
+// Message does not have this shape, which is the common case of a map value
+// that's an ordinary Message.
+func ResolveMapEntry(v *FieldType) {
+	if v.Message == nil {
+		return
+	}
+	entryValue := mapEntryValueField(v.Message)
+	if entryValue == nil {
+		return
+	}
+	*v = *entryValue.Type
+}
+
 // NewService returns a new Service struct derived from an *ast.TypeSpec with a
 // Type of *ast.InterfaceType representing an "{SVCNAME}Server" interface.
-func NewService(s *ast.TypeSpec, info *DebugInfo) (*Service, error) {
+// typespecs contains every top-level type declaration found in the same Go
+// file(s) as s, and is consulted to resolve the `{Svc}_{Method}Server` helper
+// interfaces that streaming methods are defined in terms of.
+func NewService(s *ast.TypeSpec, info *DebugInfo, typespecs []*ast.TypeSpec) (*Service, error) {
 	rv := &Service{
 		Name: strings.TrimSuffix(s.Name.Name, "Server"),
 	}
 	asvc := s.Type.(*ast.InterfaceType)
 	for _, m := range asvc.Methods.List {
-		nmeth, err := NewServiceMethod(m, info)
+		nmeth, err := NewServiceMethod(m, info, typespecs)
 		if err != nil {
 			return nil, errors.Wrapf(err, "cannot create service method %q of service %q", m.Names[0].Name, rv.Name)
 		}
@@ -344,10 +909,106 @@ func NewService(s *ast.TypeSpec, info *DebugInfo) (*Service, error) {
 	return rv, nil
 }
 
+// identName resolves the *ast.Ident naming a generated protobuf type,
+// unwrapping a leading *ast.StarExpr and/or *ast.SelectorExpr (used when the
+// type is qualified by a package, e.g. "other.Message"). It returns false if
+// e does not follow one of these conventions.
+func identName(e ast.Expr) (*ast.Ident, bool) {
+	if star, ok := e.(*ast.StarExpr); ok {
+		e = star.X
+	}
+	switch ex := e.(type) {
+	case *ast.Ident:
+		return ex, true
+	case *ast.SelectorExpr:
+		return ex.Sel, true
+	default:
+		return nil, false
+	}
+}
+
+// findInterface searches typespecs for an interface type declaration named
+// name, returning its *ast.InterfaceType if found.
+func findInterface(typespecs []*ast.TypeSpec, name string) *ast.InterfaceType {
+	for _, t := range typespecs {
+		if t.Name.Name != name {
+			continue
+		}
+		if iface, ok := t.Type.(*ast.InterfaceType); ok {
+			return iface
+		}
+	}
+	return nil
+}
+
+// findMethod returns the *ast.FuncType of the method named name on iface.
+func findMethod(iface *ast.InterfaceType, name string) *ast.FuncType {
+	for _, m := range iface.Methods.List {
+		if len(m.Names) == 0 || m.Names[0].Name != name {
+			continue
+		}
+		if ft, ok := m.Type.(*ast.FuncType); ok {
+			return ft
+		}
+	}
+	return nil
+}
+
+// classifyStreamHelper inspects a `{Svc}_{Method}Server` helper interface and
+// determines whether it is used for client-streaming or bidirectional
+// streaming, based on the methods protoc-gen-go generates for it:
+//
+//     ClientStream: Recv() (*Req, error), SendAndClose(*Resp) error
+//     BidiStream:   Recv() (*Req, error), Send(*Resp) error
+func classifyStreamHelper(iface *ast.InterfaceType) StreamKind {
+	if findMethod(iface, "SendAndClose") != nil {
+		return ClientStream
+	}
+	return BidiStream
+}
+
+// messageFromRecv resolves the request message type of a streaming method by
+// following the Recv() (*Req, error) method of its `{Svc}_{Method}Server`
+// helper interface.
+func messageFromRecv(iface *ast.InterfaceType, info *DebugInfo) (*FieldType, error) {
+	ft := findMethod(iface, "Recv")
+	if ft == nil || len(ft.Results.List) == 0 {
+		return nil, NewLocationError("stream helper interface has no usable "+
+			"Recv() method", info.Path, info.Position(iface.Pos()))
+	}
+	ident, ok := identName(ft.Results.List[0].Type)
+	if !ok {
+		return nil, NewLocationError("cannot resolve request type from Recv()",
+			info.Path, info.Position(ft.Pos()))
+	}
+	return &FieldType{Name: ident.Name, StarExpr: true}, nil
+}
+
+// messageFromSend resolves a message type by following the parameter of
+// either the Send(*Resp) error or SendAndClose(*Resp) error method of a
+// `{Svc}_{Method}Server` helper interface.
+func messageFromSend(iface *ast.InterfaceType, info *DebugInfo) (*FieldType, error) {
+	ft := findMethod(iface, "Send")
+	if ft == nil {
+		ft = findMethod(iface, "SendAndClose")
+	}
+	if ft == nil || len(ft.Params.List) == 0 {
+		return nil, NewLocationError("stream helper interface has no usable "+
+			"Send()/SendAndClose() method", info.Path, info.Position(iface.Pos()))
+	}
+	ident, ok := identName(ft.Params.List[0].Type)
+	if !ok {
+		return nil, NewLocationError("cannot resolve response type from Send()",
+			info.Path, info.Position(ft.Pos()))
+	}
+	return &FieldType{Name: ident.Name, StarExpr: true}, nil
+}
+
 // NewServiceMethod returns a new ServiceMethod derived from a method of a
 // Service interface. This is accepted in the form of an *ast.Field which
-// contains the name of the method.
-func NewServiceMethod(m *ast.Field, info *DebugInfo) (*ServiceMethod, error) {
+// contains the name of the method. typespecs is used to look up the
+// `{Svc}_{Method}Server` helper interface generated for streaming methods.
+func NewServiceMethod(m *ast.Field, info *DebugInfo, typespecs []*ast.TypeSpec) (*ServiceMethod, error) {
 	rv := &ServiceMethod{
 		Name: m.Names[0].Name,
 		SnakeName: snaker.CamelToSnake(m.Names[0].Name),
@@ -362,18 +1023,6 @@ func NewServiceMethod(m *ast.Field, info *DebugInfo) (*ServiceMethod, error) {
 	input := ft.Params.List
 	output := ft.Results.List
 
-	// Zero'th param of a serverMethod is Context.context, while first param is
-	// this methods RequestType. Example:
-	//
-	//     GetMap(context.Context, *MapTypeRequest) (*MapTypeResponse, error)
-	//                              └────────────┘    └─────────────┘
-	//                                RequestType       ResponseType
-	//            └──────────────────────────────┘   └─────────────────────┘
-	//                         input                         output
-
-	rq := input[1]
-	rs := output[0]
-
 	makeFieldType := func(in *ast.Field) (*FieldType, error) {
 		star, ok := in.Type.(*ast.StarExpr)
 		if !ok {
@@ -381,16 +1030,11 @@ func NewServiceMethod(m *ast.Field, info *DebugInfo) (*ServiceMethod, error) {
 				"is not *ast.StarExpr",
 				info.Path, info.Position(in.Pos()))
 		}
-		var ident *ast.Ident
-		ident, ok = star.X.(*ast.Ident)
+		ident, ok := identName(star)
 		if !ok {
-			expr, ok := star.X.(*ast.SelectorExpr)
-			if !ok {
-				return nil, NewLocationError("cannot create FieldType, "+
-					"star.Type is not *ast.Ident",
-					info.Path, info.Position(star.Pos()))
-			}
-			ident = expr.Sel
+			return nil, NewLocationError("cannot create FieldType, "+
+				"star.Type is not *ast.Ident",
+				info.Path, info.Position(star.Pos()))
 		}
 		return &FieldType{
 			Name:     ident.Name,
@@ -398,6 +1042,86 @@ func NewServiceMethod(m *ast.Field, info *DebugInfo) (*ServiceMethod, error) {
 		}, nil
 	}
 
+	// A streaming method's Server-interface signature only returns `error`,
+	// where a unary method returns `(*Resp, error)`. Example shapes:
+	//
+	//     Unary:        GetMap(context.Context, *MapTypeRequest) (*MapTypeResponse, error)
+	//     ServerStream: ListMaps(*MapTypeRequest, Svc_ListMapsServer) error
+	//     ClientStream: SendMaps(Svc_SendMapsServer) error
+	//     BidiStream:   MapFeed(Svc_MapFeedServer) error
+	//
+	// Context is never threaded through a stream's Server-interface method;
+	// callers instead use the `context.Context` embedded in the stream.
+	if len(output) == 1 {
+		var streamParam *ast.Field
+		switch len(input) {
+		case 2:
+			rv.StreamKind = ServerStream
+			reqType, err := makeFieldType(input[0])
+			if err != nil {
+				return nil, errors.Wrapf(err, "requestType creation of streaming service method %q failed", rv.Name)
+			}
+			rv.RequestType = reqType
+			streamParam = input[1]
+		case 1:
+			streamParam = input[0]
+		default:
+			return nil, NewLocationError(fmt.Sprintf("streaming service method "+
+				"has unexpected parameter count %d", len(input)),
+				info.Path, info.Position(m.Pos()))
+		}
+
+		streamIdent, ok := identName(streamParam.Type)
+		if !ok {
+			return nil, NewLocationError("cannot resolve stream helper "+
+				"interface for streaming service method",
+				info.Path, info.Position(streamParam.Pos()))
+		}
+		streamIface := findInterface(typespecs, streamIdent.Name)
+		if streamIface == nil {
+			return nil, NewLocationError(fmt.Sprintf("cannot find stream "+
+				"helper interface %q", streamIdent.Name),
+				info.Path, info.Position(streamParam.Pos()))
+		}
+
+		if rv.StreamKind == ServerStream {
+			respType, err := messageFromSend(streamIface, info)
+			if err != nil {
+				return nil, errors.Wrapf(err, "responseType creation of streaming service method %q failed", rv.Name)
+			}
+			rv.ResponseType = respType
+			return rv, nil
+		}
+
+		rv.StreamKind = classifyStreamHelper(streamIface)
+
+		reqType, err := messageFromRecv(streamIface, info)
+		if err != nil {
+			return nil, errors.Wrapf(err, "requestType creation of streaming service method %q failed", rv.Name)
+		}
+		rv.RequestType = reqType
+
+		respType, err := messageFromSend(streamIface, info)
+		if err != nil {
+			return nil, errors.Wrapf(err, "responseType creation of streaming service method %q failed", rv.Name)
+		}
+		rv.ResponseType = respType
+
+		return rv, nil
+	}
+
+	// Zero'th param of a serverMethod is Context.context, while first param is
+	// this methods RequestType. Example:
+	//
+	//     GetMap(context.Context, *MapTypeRequest) (*MapTypeResponse, error)
+	//                              └────────────┘    └─────────────┘
+	//                                RequestType       ResponseType
+	//            └──────────────────────────────┘   └─────────────────────┘
+	//                         input                         output
+
+	rq := input[1]
+	rs := output[0]
+
 	var err error
 	rv.RequestType, err = makeFieldType(rq)
 	if err != nil {
@@ -0,0 +1,607 @@
+package svcdef
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"strings"
+	"testing"
+)
+
+// mustParse parses src as a Go source file and returns the *ast.Field for
+// the method named methodName on the first interface type declared in it,
+// along with every top-level type declaration in the file (for resolving
+// stream helper interfaces).
+func mustParse(t *testing.T, src, methodName string) (*ast.Field, []*ast.TypeSpec) {
+	t.Helper()
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, 0)
+	if err != nil {
+		t.Fatalf("cannot parse test source: %v", err)
+	}
+	typespecs, err := retrieveTypeSpecs(f)
+	if err != nil {
+		t.Fatalf("cannot retrieve type specs: %v", err)
+	}
+	for _, ts := range typespecs {
+		iface, ok := ts.Type.(*ast.InterfaceType)
+		if !ok {
+			continue
+		}
+		for _, m := range iface.Methods.List {
+			if len(m.Names) > 0 && m.Names[0].Name == methodName {
+				return m, typespecs
+			}
+		}
+	}
+	t.Fatalf("method %q not found in test source", methodName)
+	return nil, nil
+}
+
+// firstTypeSpec returns the *ast.TypeSpec named name from src.
+func firstTypeSpec(t *testing.T, src, name string) *ast.TypeSpec {
+	t.Helper()
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, 0)
+	if err != nil {
+		t.Fatalf("cannot parse test source: %v", err)
+	}
+	typespecs, err := retrieveTypeSpecs(f)
+	if err != nil {
+		t.Fatalf("cannot retrieve type specs: %v", err)
+	}
+	for _, ts := range typespecs {
+		if ts.Name.Name == name {
+			return ts
+		}
+	}
+	t.Fatalf("type %q not found in test source", name)
+	return nil
+}
+
+func testDebugInfo() *DebugInfo {
+	return &DebugInfo{Fset: token.NewFileSet(), Path: "test.proto"}
+}
+
+func TestIsStreamHelperInterface(t *testing.T) {
+	src := `
+package test
+
+type FooServer interface {
+	ListMaps(*MapTypeRequest, Foo_ListMapsServer) error
+}
+
+type Foo_ListMapsServer interface {
+	Send(*MapTypeResponse) error
+	grpc.ServerStream
+}
+
+type isMsg_Field interface {
+	isMsg_Field()
+}
+`
+	if isStreamHelperInterface(firstTypeSpec(t, src, "FooServer")) {
+		t.Error("expected the real service interface not to be mistaken for a stream helper")
+	}
+	if !isStreamHelperInterface(firstTypeSpec(t, src, "Foo_ListMapsServer")) {
+		t.Error("expected the per-method stream helper interface to be recognized")
+	}
+	if isStreamHelperInterface(firstTypeSpec(t, src, "isMsg_Field")) {
+		t.Error("expected a oneof wrapper interface not to be mistaken for a stream helper")
+	}
+}
+
+func TestNewOneof(t *testing.T) {
+	src := `
+package test
+
+type isMsg_Field interface {
+	isMsg_Field()
+}
+
+type FooServer interface {
+	Get(context.Context, *Req) (*Resp, error)
+}
+`
+	ts := firstTypeSpec(t, src, "isMsg_Field")
+	oneof, ok := NewOneof(ts)
+	if !ok {
+		t.Fatal("expected NewOneof to recognize isMsg_Field as a oneof wrapper interface")
+	}
+	if oneof.Name != "Field" {
+		t.Errorf("expected oneof name %q, got %q", "Field", oneof.Name)
+	}
+
+	// A normal Server/Client interface must not be mistaken for a oneof.
+	ts = firstTypeSpec(t, src, "FooServer")
+	if _, ok := NewOneof(ts); ok {
+		t.Error("expected NewOneof to reject a Server interface")
+	}
+}
+
+func TestNewOneofVariant(t *testing.T) {
+	src := `
+package test
+
+type Msg_FieldA struct {
+	FieldA string ` + "`protobuf:\"bytes,2,opt,name=field_a,oneof\"`" + `
+}
+`
+	ts := firstTypeSpec(t, src, "Msg_FieldA")
+	variant, err := NewOneofVariant(ts)
+	if err != nil {
+		t.Fatalf("NewOneofVariant returned error: %v", err)
+	}
+	if variant.Name != "FieldA" {
+		t.Errorf("expected variant name %q, got %q", "FieldA", variant.Name)
+	}
+	if variant.FieldType.Name != "string" {
+		t.Errorf("expected variant field type %q, got %q", "string", variant.FieldType.Name)
+	}
+}
+
+func TestNewLinksOneofRegardlessOfDeclarationOrder(t *testing.T) {
+	// The oneof wrapper struct is declared before the "isMsg_Field" interface
+	// it implements, the reverse of NewOneof/NewOneofVariant's usual fixture
+	// order, to exercise New's two-pass resolution.
+	goSrc := `
+package test
+
+type Msg_FieldA struct {
+	FieldA string ` + "`protobuf:\"bytes,2,opt,name=field_a,oneof\"`" + `
+}
+
+func (*Msg_FieldA) isMsg_Field() {}
+
+type isMsg_Field interface {
+	isMsg_Field()
+}
+
+type Msg struct {
+	Field isMsg_Field ` + "`protobuf_oneof:\"field\"`" + `
+}
+`
+	sd, err := New(map[string]io.Reader{"test.go": strings.NewReader(goSrc)}, nil)
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+
+	var msg *Message
+	for _, m := range sd.Messages {
+		if m.Name == "Msg" {
+			msg = m
+		}
+	}
+	if msg == nil {
+		t.Fatal("expected a Msg message")
+	}
+	if len(msg.Oneofs) != 1 || len(msg.Oneofs[0].Variants) != 1 {
+		t.Fatalf("expected Msg to have one oneof with one variant, got %+v", msg.Oneofs)
+	}
+	if msg.Oneofs[0].Variants[0].Name != "FieldA" {
+		t.Errorf("expected variant %q, got %q", "FieldA", msg.Oneofs[0].Variants[0].Name)
+	}
+}
+
+func TestResolveFieldPath(t *testing.T) {
+	zip := &Field{Name: "Zip", PBFieldName: "zip", Type: &FieldType{Name: "string"}}
+	address := &Message{Name: "Address", Fields: []*Field{zip}}
+	addressField := &Field{Name: "Address", PBFieldName: "address", Type: &FieldType{Name: "Address", Message: address, StarExpr: true}}
+	user := &Message{Name: "User", Fields: []*Field{addressField}}
+	userField := &Field{Name: "User", PBFieldName: "user", Type: &FieldType{Name: "User", Message: user, StarExpr: true}}
+	id := &Field{Name: "Id", PBFieldName: "id", Type: &FieldType{Name: "string"}}
+	root := &Message{Name: "Request", Fields: []*Field{userField, id}}
+
+	chain, err := ResolveFieldPath(root, "user.address.zip", testDebugInfo(), 0)
+	if err != nil {
+		t.Fatalf("ResolveFieldPath returned error: %v", err)
+	}
+	if len(chain) != 3 || chain[0] != userField || chain[1] != addressField || chain[2] != zip {
+		t.Errorf("unexpected field path: %+v", chain)
+	}
+
+	if _, err := ResolveFieldPath(root, "user.address", testDebugInfo(), 0); err == nil {
+		t.Error("expected ResolveFieldPath to reject a path resolving to a Message leaf")
+	}
+
+	chain, err = ResolveFieldPath(root, "id", testDebugInfo(), 0)
+	if err != nil {
+		t.Fatalf("ResolveFieldPath returned error: %v", err)
+	}
+	if len(chain) != 1 || chain[0] != id {
+		t.Errorf("unexpected single-segment field path: %+v", chain)
+	}
+}
+
+func TestResolveFieldPathRejectsMessageLeaf(t *testing.T) {
+	address := &Message{Name: "Address", Fields: nil}
+	addressField := &Field{Name: "Address", PBFieldName: "address", Type: &FieldType{Name: "Address", Message: address, StarExpr: true}}
+	root := &Message{Name: "User", Fields: []*Field{addressField}}
+
+	if _, err := ResolveFieldPath(root, "address", testDebugInfo(), 0); err == nil {
+		t.Error("expected ResolveFieldPath to reject a path resolving to a Message leaf")
+	}
+}
+
+func TestResolveFieldPathRejectsUnknownSegment(t *testing.T) {
+	root := &Message{Name: "User", Fields: nil}
+
+	if _, err := ResolveFieldPath(root, "nonexistent", testDebugInfo(), 0); err == nil {
+		t.Error("expected ResolveFieldPath to reject an unknown field")
+	}
+}
+
+func TestNewMapBasetypeValue(t *testing.T) {
+	ts := firstTypeSpec(t, `
+package test
+
+type Holder struct {
+	Field map[string]int64
+}
+`, "Holder")
+	field := ts.Type.(*ast.StructType).Fields.List[0]
+	mp, ok := field.Type.(*ast.MapType)
+	if !ok {
+		t.Fatalf("test field is not a map")
+	}
+	m, err := NewMap(mp)
+	if err != nil {
+		t.Fatalf("NewMap returned error: %v", err)
+	}
+	if m.KeyType.Name != "string" || m.ValueType.Name != "int64" || m.ValueType.StarExpr {
+		t.Errorf("unexpected map: %+v / %+v", m.KeyType, m.ValueType)
+	}
+}
+
+func TestNewMapMessageValue(t *testing.T) {
+	ts := firstTypeSpec(t, `
+package test
+
+type Holder struct {
+	Field map[string]*Msg_FieldEntry
+}
+`, "Holder")
+	field := ts.Type.(*ast.StructType).Fields.List[0]
+	mp := field.Type.(*ast.MapType)
+	m, err := NewMap(mp)
+	if err != nil {
+		t.Fatalf("NewMap returned error: %v", err)
+	}
+	if m.ValueType.Name != "Msg_FieldEntry" || !m.ValueType.StarExpr {
+		t.Errorf("unexpected value type: %+v", m.ValueType)
+	}
+}
+
+func TestResolveMapEntryPromotesNestedMap(t *testing.T) {
+	innerMap := &Map{KeyType: &FieldType{Name: "string"}, ValueType: &FieldType{Name: "string"}}
+	entryMsg := &Message{
+		Name: "Msg_FieldEntry",
+		Fields: []*Field{
+			{Name: "Key", Type: &FieldType{Name: "string"}},
+			{Name: "Value", Type: &FieldType{Name: "", Map: innerMap}},
+		},
+	}
+	v := &FieldType{Name: "Msg_FieldEntry", Message: entryMsg, StarExpr: true}
+
+	ResolveMapEntry(v)
+
+	if v.Map != innerMap {
+		t.Errorf("expected ResolveMapEntry to promote the nested map, got %+v", v)
+	}
+	if v.Message != nil {
+		t.Errorf("expected ResolveMapEntry to clear the synthetic entry message, got %+v", v.Message)
+	}
+}
+
+func TestResolveMapEntryLeavesOrdinaryMessageAlone(t *testing.T) {
+	msg := &Message{
+		Name: "User",
+		Fields: []*Field{
+			{Name: "Name", Type: &FieldType{Name: "string"}},
+		},
+	}
+	v := &FieldType{Name: "User", Message: msg, StarExpr: true}
+
+	ResolveMapEntry(v)
+
+	if v.Message != msg {
+		t.Errorf("expected ResolveMapEntry to leave an ordinary message value alone, got %+v", v)
+	}
+}
+
+func TestNewResolvesTypesAndNestedMapEntries(t *testing.T) {
+	goSrc := `
+package test
+
+type GetUserRequest struct {
+	UserId string ` + "`protobuf:\"bytes,1,opt,name=user_id,json=userId\" json:\"user_id,omitempty\"`" + `
+}
+
+type GetUserResponse struct {
+	Name string ` + "`protobuf:\"bytes,1,opt,name=name\" json:\"name,omitempty\"`" + `
+	// A map value that is itself a map becomes a synthetic "NestedEntry"
+	// message wrapping a "Value" field, the shape protoc-gen-go generates
+	// for a map<string, map<string, string>> field.
+	Nested map[string]*GetUserResponse_NestedEntry ` + "`protobuf:\"bytes,2,rep,name=nested\"`" + `
+}
+
+type GetUserResponse_NestedEntry struct {
+	Key   string            ` + "`protobuf:\"bytes,1,opt,name=key\"`" + `
+	Value map[string]string ` + "`protobuf:\"bytes,2,rep,name=value\"`" + `
+}
+
+type FooServer interface {
+	GetUser(context.Context, *GetUserRequest) (*GetUserResponse, error)
+}
+`
+	sd, err := New(map[string]io.Reader{"test.go": strings.NewReader(goSrc)}, nil)
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+
+	method := sd.Service.Methods[0]
+	if method.RequestType.Message == nil || method.RequestType.Message.Name != "GetUserRequest" {
+		t.Fatalf("expected resolveTypes to bind RequestType.Message, got %+v", method.RequestType)
+	}
+	if method.ResponseType.Message == nil || method.ResponseType.Message.Name != "GetUserResponse" {
+		t.Fatalf("expected resolveTypes to bind ResponseType.Message, got %+v", method.ResponseType)
+	}
+
+	var nested *Field
+	for _, f := range method.ResponseType.Message.Fields {
+		if f.Name == "Nested" {
+			nested = f
+		}
+	}
+	if nested == nil {
+		t.Fatal("expected a Nested field on GetUserResponse")
+	}
+	if nested.Type.Map == nil {
+		t.Fatalf("expected Nested to be a map, got %+v", nested.Type)
+	}
+	if nested.Type.Map.ValueType.Message != nil {
+		t.Errorf("expected resolveTypes to unwrap the synthetic entry message, got %+v", nested.Type.Map.ValueType)
+	}
+	if nested.Type.Map.ValueType.Map == nil || nested.Type.Map.ValueType.Map.ValueType.Name != "string" {
+		t.Errorf("expected the nested map to be promoted onto the outer value type, got %+v", nested.Type.Map.ValueType)
+	}
+}
+
+func TestNewWiresHTTPBindings(t *testing.T) {
+	goSrc := `
+package test
+
+type GetUserRequest struct {
+	UserId string ` + "`protobuf:\"bytes,1,opt,name=user_id,json=userId\" json:\"user_id,omitempty\"`" + `
+	Extra  string ` + "`protobuf:\"bytes,2,opt,name=extra,json=extra\" json:\"extra,omitempty\"`" + `
+}
+
+type GetUserResponse struct {
+	Name string ` + "`protobuf:\"bytes,1,opt,name=name\" json:\"name,omitempty\"`" + `
+}
+
+type FooServer interface {
+	GetUser(context.Context, *GetUserRequest) (*GetUserResponse, error)
+}
+`
+	protoSrc := `
+syntax = "proto3";
+
+service Foo {
+	rpc GetUser(GetUserRequest) returns (GetUserResponse) {
+		option (google.api.http) = {
+			get: "/v1/users/{user_id}"
+		};
+	}
+}
+`
+	sd, err := New(
+		map[string]io.Reader{"test.go": strings.NewReader(goSrc)},
+		map[string]io.Reader{"test.proto": strings.NewReader(protoSrc)},
+	)
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+
+	method := sd.Service.Methods[0]
+	if len(method.Bindings) != 1 {
+		t.Fatalf("expected 1 HTTP binding, got %d", len(method.Bindings))
+	}
+	binding := method.Bindings[0]
+	if binding.Verb != "GET" || binding.Path != "/v1/users/{user_id}" {
+		t.Errorf("unexpected binding: %+v", binding)
+	}
+	if len(binding.Params) != 2 {
+		t.Fatalf("expected 2 HTTP parameters, got %d", len(binding.Params))
+	}
+
+	byName := map[string]*HTTPParameter{}
+	for _, p := range binding.Params {
+		byName[p.Field.Name] = p
+	}
+	if p := byName["UserId"]; p == nil || p.Location != "path" || len(p.FieldPath) != 1 {
+		t.Errorf("expected UserId to be a path parameter, got %+v", p)
+	}
+	if p := byName["Extra"]; p == nil || p.Location != "query" {
+		t.Errorf("expected Extra to be a query parameter, got %+v", p)
+	}
+}
+
+func TestNewWiresHTTPBindingsSkipsBodylessPredecessor(t *testing.T) {
+	goSrc := `
+package test
+
+type GetUserRequest struct {
+	UserId string ` + "`protobuf:\"bytes,1,opt,name=user_id,json=userId\" json:\"user_id,omitempty\"`" + `
+}
+
+type GetUserResponse struct {
+	Name string ` + "`protobuf:\"bytes,1,opt,name=name\" json:\"name,omitempty\"`" + `
+}
+
+type FooServer interface {
+	Ping(context.Context, *GetUserRequest) (*GetUserResponse, error)
+	GetUser(context.Context, *GetUserRequest) (*GetUserResponse, error)
+}
+`
+	protoSrc := `
+syntax = "proto3";
+
+service Foo {
+	rpc Ping(GetUserRequest) returns (GetUserResponse);
+
+	rpc GetUser(GetUserRequest) returns (GetUserResponse) {
+		option (google.api.http) = {
+			get: "/v1/users/{user_id}"
+		};
+	}
+}
+`
+	sd, err := New(
+		map[string]io.Reader{"test.go": strings.NewReader(goSrc)},
+		map[string]io.Reader{"test.proto": strings.NewReader(protoSrc)},
+	)
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+
+	byName := map[string]*ServiceMethod{}
+	for _, m := range sd.Service.Methods {
+		byName[m.Name] = m
+	}
+	if len(byName["Ping"].Bindings) != 0 {
+		t.Errorf("expected the bare `;` rpc to have no bindings, got %+v", byName["Ping"].Bindings)
+	}
+	if len(byName["GetUser"].Bindings) != 1 {
+		t.Fatalf("expected GetUser's own binding, not its predecessor's, got %+v", byName["GetUser"].Bindings)
+	}
+	if byName["GetUser"].Bindings[0].Path != "/v1/users/{user_id}" {
+		t.Errorf("unexpected binding stolen by GetUser: %+v", byName["GetUser"].Bindings[0])
+	}
+}
+
+func TestMapEntryValueFieldRequiresEntryNaming(t *testing.T) {
+	real := &Message{
+		Name: "RealMsg",
+		Fields: []*Field{
+			{Name: "Key", Type: &FieldType{Name: "string"}},
+			{Name: "Value", Type: &FieldType{Name: "string"}},
+		},
+	}
+	if f := mapEntryValueField(real); f != nil {
+		t.Errorf("expected a legitimate message named Key/Value fields not to be mistaken for a synthetic map entry, got %+v", f)
+	}
+
+	entry := &Message{
+		Name: "GetUserResponse_NestedEntry",
+		Fields: []*Field{
+			{Name: "Key", Type: &FieldType{Name: "string"}},
+			{Name: "Value", Type: &FieldType{Name: "string"}},
+		},
+	}
+	if f := mapEntryValueField(entry); f != entry.Fields[1] {
+		t.Errorf("expected a synthetic map-entry message to be recognized, got %+v", f)
+	}
+}
+
+func TestNewServiceMethodUnary(t *testing.T) {
+	src := `
+package test
+
+type FooServer interface {
+	GetMap(context.Context, *MapTypeRequest) (*MapTypeResponse, error)
+}
+`
+	m, typespecs := mustParse(t, src, "GetMap")
+	sm, err := NewServiceMethod(m, nil, typespecs)
+	if err != nil {
+		t.Fatalf("NewServiceMethod returned error: %v", err)
+	}
+	if sm.StreamKind != UnaryStream {
+		t.Errorf("expected UnaryStream, got %s", sm.StreamKind)
+	}
+	if sm.RequestType.Name != "MapTypeRequest" || sm.ResponseType.Name != "MapTypeResponse" {
+		t.Errorf("unexpected request/response types: %+v / %+v", sm.RequestType, sm.ResponseType)
+	}
+}
+
+func TestNewServiceMethodServerStream(t *testing.T) {
+	src := `
+package test
+
+type FooServer interface {
+	ListMaps(*MapTypeRequest, Foo_ListMapsServer) error
+}
+
+type Foo_ListMapsServer interface {
+	Send(*MapTypeResponse) error
+	grpc.ServerStream
+}
+`
+	m, typespecs := mustParse(t, src, "ListMaps")
+	sm, err := NewServiceMethod(m, nil, typespecs)
+	if err != nil {
+		t.Fatalf("NewServiceMethod returned error: %v", err)
+	}
+	if sm.StreamKind != ServerStream {
+		t.Errorf("expected ServerStream, got %s", sm.StreamKind)
+	}
+	if sm.RequestType.Name != "MapTypeRequest" || sm.ResponseType.Name != "MapTypeResponse" {
+		t.Errorf("unexpected request/response types: %+v / %+v", sm.RequestType, sm.ResponseType)
+	}
+}
+
+func TestNewServiceMethodClientStream(t *testing.T) {
+	src := `
+package test
+
+type FooServer interface {
+	SendMaps(Foo_SendMapsServer) error
+}
+
+type Foo_SendMapsServer interface {
+	SendAndClose(*MapTypeResponse) error
+	Recv() (*MapTypeRequest, error)
+	grpc.ServerStream
+}
+`
+	m, typespecs := mustParse(t, src, "SendMaps")
+	sm, err := NewServiceMethod(m, nil, typespecs)
+	if err != nil {
+		t.Fatalf("NewServiceMethod returned error: %v", err)
+	}
+	if sm.StreamKind != ClientStream {
+		t.Errorf("expected ClientStream, got %s", sm.StreamKind)
+	}
+	if sm.RequestType.Name != "MapTypeRequest" || sm.ResponseType.Name != "MapTypeResponse" {
+		t.Errorf("unexpected request/response types: %+v / %+v", sm.RequestType, sm.ResponseType)
+	}
+}
+
+func TestNewServiceMethodBidiStream(t *testing.T) {
+	src := `
+package test
+
+type FooServer interface {
+	MapFeed(Foo_MapFeedServer) error
+}
+
+type Foo_MapFeedServer interface {
+	Send(*MapTypeResponse) error
+	Recv() (*MapTypeRequest, error)
+	grpc.ServerStream
+}
+`
+	m, typespecs := mustParse(t, src, "MapFeed")
+	sm, err := NewServiceMethod(m, nil, typespecs)
+	if err != nil {
+		t.Fatalf("NewServiceMethod returned error: %v", err)
+	}
+	if sm.StreamKind != BidiStream {
+		t.Errorf("expected BidiStream, got %s", sm.StreamKind)
+	}
+	if sm.RequestType.Name != "MapTypeRequest" || sm.ResponseType.Name != "MapTypeResponse" {
+		t.Errorf("unexpected request/response types: %+v / %+v", sm.RequestType, sm.ResponseType)
+	}
+}
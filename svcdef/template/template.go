@@ -0,0 +1,277 @@
+// Package template implements a user-extensible plugin mechanism for Truss,
+// modeled on protoc-gen-gotemplate: point it at a directory of Go
+// text/template files and get back one rendered output file per template
+// execution, with a resolved *svcdef.Svcdef (or one of its constituent
+// pieces) handed to the template as ".". This lets users generate things
+// like OpenAPI specs, TypeScript clients, or custom middleware wiring
+// without forking Truss's built-in templates.
+package template
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"github.com/serenize/snaker"
+
+	"github.com/TuneLab/go-truss/svcdef"
+)
+
+// Scope controls how many times a template file is executed, and what data
+// it's handed each time.
+type Scope string
+
+const (
+	// ScopeService executes the template once, with the whole *svcdef.Svcdef
+	// as data. This is the default scope.
+	ScopeService Scope = "service"
+	// ScopeMethod executes the template once per ServiceMethod, with the
+	// *svcdef.ServiceMethod as data.
+	ScopeMethod Scope = "method"
+	// ScopeMessage executes the template once per Message, with the
+	// *svcdef.Message as data.
+	ScopeMessage Scope = "message"
+)
+
+// Header is the front-matter a template file declares about itself, found in
+// a leading comment of the form:
+//
+//     {{/*
+//     output: {{.Name}}.graphql
+//     scope: message
+//     tags: graphql
+//     */}}
+type Header struct {
+	// Output is itself a text/template string, rendered against the same
+	// data as the template body, which produces the output file's path.
+	Output string
+	// Scope controls how many times this template is executed. Defaults to
+	// ScopeService if unset.
+	Scope Scope
+	// Tags holds Go build tags to place at the top of the rendered file, if
+	// any.
+	Tags []string
+}
+
+// File is one rendered output produced by executing a template.
+type File struct {
+	Path string
+	Data []byte
+}
+
+// Plugin renders every "*.tmpl" file found in dir against sd, returning one
+// File per template execution: one for ScopeService, one per-method for
+// ScopeMethod, or one per-message for ScopeMessage, depending on the
+// template's declared Header.Scope.
+func Plugin(dir string, sd *svcdef.Svcdef) ([]File, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot list template directory %q", dir)
+	}
+
+	var rv []File
+	for _, path := range paths {
+		files, err := renderFile(path, sd)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot render template %q", path)
+		}
+		rv = append(rv, files...)
+	}
+	return rv, nil
+}
+
+func renderFile(path string, sd *svcdef.Svcdef) ([]File, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read template %q", path)
+	}
+	hdr, body, err := splitHeader(raw)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot parse front-matter header")
+	}
+
+	tmpl, err := template.New(filepath.Base(path)).Funcs(FuncMap()).Parse(body)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot parse template body")
+	}
+	outTmpl, err := template.New(filepath.Base(path) + ".output").Funcs(FuncMap()).Parse(hdr.Output)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot parse output path")
+	}
+
+	var rv []File
+	for _, data := range scopeData(hdr.Scope, sd) {
+		f, err := render(tmpl, outTmpl, hdr, data)
+		if err != nil {
+			return nil, err
+		}
+		rv = append(rv, f)
+	}
+	return rv, nil
+}
+
+// scopeData returns the list of data values a template declaring scope
+// should be executed once for.
+func scopeData(scope Scope, sd *svcdef.Svcdef) []interface{} {
+	switch scope {
+	case ScopeMethod:
+		if sd.Service == nil {
+			return nil
+		}
+		rv := make([]interface{}, 0, len(sd.Service.Methods))
+		for _, m := range sd.Service.Methods {
+			rv = append(rv, m)
+		}
+		return rv
+	case ScopeMessage:
+		rv := make([]interface{}, 0, len(sd.Messages))
+		for _, m := range sd.Messages {
+			rv = append(rv, m)
+		}
+		return rv
+	default:
+		return []interface{}{sd}
+	}
+}
+
+func render(tmpl, outTmpl *template.Template, hdr *Header, data interface{}) (File, error) {
+	var outBuf bytes.Buffer
+	if err := outTmpl.Execute(&outBuf, data); err != nil {
+		return File{}, errors.Wrap(err, "cannot render output path")
+	}
+
+	var body bytes.Buffer
+	for _, tag := range hdr.Tags {
+		fmt.Fprintf(&body, "// +build %s\n\n", tag)
+	}
+	if err := tmpl.Execute(&body, data); err != nil {
+		return File{}, errors.Wrap(err, "cannot render template body")
+	}
+
+	return File{Path: outBuf.String(), Data: body.Bytes()}, nil
+}
+
+// splitHeader extracts the leading `{{/* ... */}}` front-matter comment from
+// a template file's raw contents, parsing its "key: value" lines into a
+// Header, and returns the template body that follows it.
+func splitHeader(raw []byte) (*Header, string, error) {
+	const open = "{{/*"
+	const closeTag = "*/}}"
+
+	src := string(raw)
+	trimmed := strings.TrimLeft(src, " \t\r\n")
+	if !strings.HasPrefix(trimmed, open) {
+		return nil, "", errors.New("template is missing its front-matter header")
+	}
+	start := strings.Index(src, open) + len(open)
+	rel := strings.Index(src[start:], closeTag)
+	if rel == -1 {
+		return nil, "", errors.New("template's front-matter header is never closed")
+	}
+	end := start + rel
+
+	hdr := &Header{Scope: ScopeService}
+	scanner := bufio.NewScanner(strings.NewReader(src[start:end]))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return nil, "", errors.Errorf("malformed front-matter line %q", line)
+		}
+		key, val := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		switch key {
+		case "output":
+			hdr.Output = val
+		case "scope":
+			hdr.Scope = Scope(val)
+		case "tags":
+			hdr.Tags = strings.Fields(val)
+		default:
+			return nil, "", errors.Errorf("unknown front-matter key %q", key)
+		}
+	}
+	if hdr.Output == "" {
+		return nil, "", errors.New(`template's front-matter header is missing an "output" path`)
+	}
+
+	return hdr, src[end+len(closeTag):], nil
+}
+
+// FuncMap returns the set of helper functions available to a user template,
+// exposing common svcdef queries and Truss's naming conventions.
+func FuncMap() template.FuncMap {
+	return template.FuncMap{
+		"snakeCase": snaker.CamelToSnake,
+		"goType":    goType,
+		"pbType":    pbType,
+		"isMessage": func(ft *svcdef.FieldType) bool { return ft != nil && ft.Message != nil },
+		"isMap":     func(ft *svcdef.FieldType) bool { return ft != nil && ft.Map != nil },
+		"httpVerb":  httpVerb,
+		"leafPath":  leafPath,
+	}
+}
+
+// goType returns the Go type of a FieldType as it would appear in generated
+// code, e.g. "*MapTypeRequest", "[]string", "map[string]int64".
+func goType(ft *svcdef.FieldType) string {
+	if ft == nil {
+		return ""
+	}
+	name := ft.Name
+	if ft.Map != nil {
+		name = fmt.Sprintf("map[%s]%s", goType(ft.Map.KeyType), goType(ft.Map.ValueType))
+	} else if ft.StarExpr {
+		name = "*" + name
+	}
+	if ft.ArrayType {
+		name = "[]" + name
+	}
+	return name
+}
+
+// pbType returns the protobuf scalar/message/enum type name of a FieldType
+// as it would appear in a .proto file, e.g. "string", "MapTypeRequest".
+// The Go type does not record a field's integer encoding, so sint32, fixed32
+// and sfixed32 fields report "int32" (likewise for the 64-bit types), and a
+// nested message keeps its Go name, e.g. "Msg_Inner".
+func pbType(ft *svcdef.FieldType) string {
+	if ft == nil {
+		return ""
+	}
+	switch {
+	case ft.Name == "float64":
+		return "double"
+	case ft.Name == "float32":
+		return "float"
+	case ft.Name == "byte" && ft.ArrayType:
+		return "bytes"
+	}
+	return ft.Name
+}
+
+// httpVerb returns the HTTP verb of a ServiceMethod's first binding, or "" if
+// it has none, e.g. a non-unary streaming method with no HTTP gateway.
+func httpVerb(m *svcdef.ServiceMethod) string {
+	if len(m.Bindings) == 0 {
+		return ""
+	}
+	return m.Bindings[0].Verb
+}
+
+// leafPath renders an HTTPParameter's FieldPath as a dotted path, e.g.
+// "user.address.zip", matching the syntax of the original HTTP annotation.
+func leafPath(p *svcdef.HTTPParameter) string {
+	names := make([]string, len(p.FieldPath))
+	for i, f := range p.FieldPath {
+		names[i] = f.PBFieldName
+	}
+	return strings.Join(names, ".")
+}
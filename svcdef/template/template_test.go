@@ -0,0 +1,91 @@
+package template
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/TuneLab/go-truss/svcdef"
+)
+
+func TestSplitHeader(t *testing.T) {
+	src := []byte(`{{/*
+output: {{.Name}}.graphql
+scope: message
+tags: graphql
+*/}}
+type {{.Name}} struct{}
+`)
+	hdr, body, err := splitHeader(src)
+	if err != nil {
+		t.Fatalf("splitHeader returned error: %v", err)
+	}
+	if hdr.Output != "{{.Name}}.graphql" {
+		t.Errorf("unexpected Output: %q", hdr.Output)
+	}
+	if hdr.Scope != ScopeMessage {
+		t.Errorf("unexpected Scope: %q", hdr.Scope)
+	}
+	if len(hdr.Tags) != 1 || hdr.Tags[0] != "graphql" {
+		t.Errorf("unexpected Tags: %v", hdr.Tags)
+	}
+	if body != "\ntype {{.Name}} struct{}\n" {
+		t.Errorf("unexpected body: %q", body)
+	}
+}
+
+func TestSplitHeaderMissingOutput(t *testing.T) {
+	src := []byte(`{{/*
+scope: message
+*/}}
+body
+`)
+	if _, _, err := splitHeader(src); err == nil {
+		t.Error("expected splitHeader to reject a header missing its output path")
+	}
+}
+
+func TestPluginScopeMessage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "truss-template-test")
+	if err != nil {
+		t.Fatalf("cannot create temp dir: %v", err)
+	}
+
+	tmplSrc := `{{/*
+output: {{.Name | snakeCase}}.txt
+scope: message
+*/}}
+message {{.Name}}
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "msg.tmpl"), []byte(tmplSrc), 0644); err != nil {
+		t.Fatalf("cannot write template: %v", err)
+	}
+
+	sd := &svcdef.Svcdef{
+		Messages: []*svcdef.Message{
+			{Name: "MapType"},
+			{Name: "OtherType"},
+		},
+	}
+
+	files, err := Plugin(dir, sd)
+	if err != nil {
+		t.Fatalf("Plugin returned error: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected 2 rendered files, got %d", len(files))
+	}
+	if files[0].Path != "map_type.txt" {
+		t.Errorf("unexpected output path: %q", files[0].Path)
+	}
+	if string(files[0].Data) != "\nmessage MapType\n" {
+		t.Errorf("unexpected output data: %q", files[0].Data)
+	}
+}
+
+func TestFuncMapGoType(t *testing.T) {
+	ft := &svcdef.FieldType{Name: "MapTypeRequest", StarExpr: true, ArrayType: true}
+	if got := goType(ft); got != "[]*MapTypeRequest" {
+		t.Errorf("unexpected goType: %q", got)
+	}
+}